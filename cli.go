@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bash-runtime/config"
+	"bash-runtime/runner"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// propertyFlags collects the repeated "--prop k=v" flags
+type propertyFlags map[string]string
+
+func (properties propertyFlags) String() string {
+	var pairs []string
+	for name, value := range properties {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (properties propertyFlags) Set(pair string) error {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid property '%s', it should be like k=v", pair)
+	}
+	properties[parts[0]] = parts[1]
+	return nil
+}
+
+// invoke runs the script once for the message given in the arguments, and prints the output messages to stdout.
+// The other settings are loaded like running against pulsar.
+func invoke(args []string) int {
+	properties := propertyFlags{}
+	flags := flag.NewFlagSet("invoke", flag.ContinueOnError)
+	loader := config.NewLoader(flags)
+	payload := flags.String("payload", "", "the payload of the message")
+	key := flags.String("key", "", "the key of the message")
+	topic := flags.String("topic", "", "the topic the message comes from, the first of the in_topics by default")
+	flags.Var(properties, "prop", "a property of the message like k=v, it can be repeated")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		logrus.Errorf("Failed to load config: %s", err)
+		return 1
+	}
+	if *topic == "" && len(cfg.InTopics) > 0 {
+		*topic = cfg.InTopics[0]
+	}
+
+	source := runner.NewMemorySource(1)
+	source.Add(runner.NewMessage(runner.Record{
+		ID:         "1",
+		Topic:      *topic,
+		Key:        *key,
+		Properties: properties,
+		Payload:    []byte(*payload),
+	}))
+	source.End()
+	return runLocally(cfg, source, "-")
+}
+
+// replay runs the script for every message of a JSON lines file, and prints the output messages to stdout
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	loader := config.NewLoader(flags)
+	input := flags.String("input", "-", "the JSON lines file of the messages, - means stdin")
+	output := flags.String("output", "-", "the JSON lines file of the output messages, - means stdout")
+	topic := flags.String("topic", "", "the topic of the messages which don't have one, the first of the in_topics by default")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		logrus.Errorf("Failed to load config: %s", err)
+		return 1
+	}
+	if *topic == "" && len(cfg.InTopics) > 0 {
+		*topic = cfg.InTopics[0]
+	}
+
+	source, err := runner.OpenFileSource(*input, *topic)
+	if err != nil {
+		logrus.Errorf("Failed to open the input: %s", err)
+		return 1
+	}
+	return runLocally(cfg, source, *output)
+}
+
+// runLocally runs the script for the messages of the source without a broker, the output messages are written to
+// the output file. It returns 1 when any of the messages fails.
+func runLocally(cfg config.Config, source localSource, output string) int {
+	// keep stdout for the output messages
+	logrus.SetOutput(os.Stderr)
+	if err := runner.SetupLogging(cfg.LogConfig()); err != nil {
+		logrus.Errorf("Failed to set up logging: %s", err)
+		return 1
+	}
+	sink, err := runner.CreateFileSink(output)
+	if err != nil {
+		logrus.Errorf("Failed to create the output: %s", err)
+		return 1
+	}
+	shutdownTracing, err := runner.SetupTracing(cfg.TracingConfig())
+	if err != nil {
+		logrus.Errorf("Failed to set up tracing: %s", err)
+		return 1
+	}
+	defer flushTraces(shutdownTracing)
+	scriptRunner, err := runner.NewRunnerWith(cfg.RunnerConfig(), source, sink)
+	if err != nil {
+		logrus.Errorf("Failed to initialize script runner: %s", err)
+		return 1
+	}
+	defer scriptRunner.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	err = scriptRunner.Run(ctx, cfg.Script)
+	scriptRunner.Flush()
+	if err != nil {
+		logrus.Errorf("Failed to run the script: %s", err)
+		return 1
+	}
+
+	acked, nacked, reconsumed := len(source.Acked()), len(source.Nacked()), len(source.Reconsumed())
+	logrus.Infof("%d messages processed, %d acked, %d failed, %d sent to the retry letter topic",
+		acked+nacked+reconsumed, acked, nacked, reconsumed)
+	if nacked+reconsumed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// localSource is a source which records how the messages are handled
+type localSource interface {
+	runner.Source
+	Acked() []runner.Message
+	Nacked() []runner.Message
+	Reconsumed() []runner.Message
+}
@@ -1,8 +1,38 @@
 package common
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrScriptNotExist = errors.New("given script file doesn't exist")
 	ErrScriptExecError = errors.New("failed to run the given script file")
+	ErrScriptTimeout = errors.New("the given script file is killed for running too long")
+	ErrInvalidOutput = errors.New("the output of the given script file is invalid")
 )
+
+// ScriptExitError is returned when the script exits with a non-zero code, it matches ErrScriptExecError by errors.Is
+type ScriptExitError struct {
+	ExitCode int
+}
+
+func (e *ScriptExitError) Error() string {
+	return fmt.Sprintf("%s, exit code: %d", ErrScriptExecError, e.ExitCode)
+}
+
+func (e *ScriptExitError) Is(target error) bool {
+	return target == ErrScriptExecError
+}
+
+// ExitCode returns the exit code carried by the error, 0 for nil and -1 when the script didn't exit by itself
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ScriptExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode
+	}
+	return -1
+}
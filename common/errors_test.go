@@ -0,0 +1,48 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		want      int
+		isExecErr bool
+	}{
+		{
+			name:      "it should return 0 when there is no error",
+			err:       nil,
+			want:      0,
+			isExecErr: false,
+		},
+		{
+			name:      "it should return the exit code of the script",
+			err:       &ScriptExitError{ExitCode: 2},
+			want:      2,
+			isExecErr: true,
+		},
+		{
+			name:      "it should return the exit code of a wrapped error",
+			err:       fmt.Errorf("wrapped: %w", &ScriptExitError{ExitCode: 3}),
+			want:      3,
+			isExecErr: true,
+		},
+		{
+			name:      "it should return -1 when the script didn't exit by itself",
+			err:       ErrScriptNotExist,
+			want:      -1,
+			isExecErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ExitCode(tt.err))
+			assert.Equal(t, tt.isExecErr, errors.Is(tt.err, ErrScriptExecError))
+		})
+	}
+}
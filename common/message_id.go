@@ -0,0 +1,47 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"strconv"
+	"strings"
+)
+
+// FormatMessageID formats the message id as "ledgerId:entryId:partitionIdx:batchIdx"
+func FormatMessageID(id pulsar.MessageID) string {
+	if id == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d:%d:%d", id.LedgerID(), id.EntryID(), id.PartitionIdx(), id.BatchIdx())
+}
+
+// ParseMessageID parses the message id formatted by FormatMessageID, the batchIdx can be omitted
+func ParseMessageID(s string) (pulsar.MessageID, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 && len(parts) != 4 {
+		return nil, fmt.Errorf("invalid message id '%s', should be like ledgerId:entryId:partitionIdx:batchIdx", s)
+	}
+	numbers := []int64{-1, -1, -1, -1}
+	for i, part := range parts {
+		number, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message id '%s', %s", s, err)
+		}
+		numbers[i] = number
+	}
+	return NewMessageID(numbers[0], numbers[1], int32(numbers[2]), int32(numbers[3]))
+}
+
+// NewMessageID creates a message id which can be used to seek, pulsar doesn't accept the message ids implemented
+// outside of it, so it's built from the protobuf encoding of the id
+func NewMessageID(ledgerID int64, entryID int64, partitionIdx int32, batchIdx int32) (pulsar.MessageID, error) {
+	// the fields of MessageIdData: ledgerId = 1, entryId = 2, partition = 3, batch_index = 4, all of them are varints
+	var data []byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	for i, value := range []int64{ledgerID, entryID, int64(partitionIdx), int64(batchIdx)} {
+		data = append(data, byte((i+1)<<3))
+		data = append(data, buf[:binary.PutUvarint(buf, uint64(value))]...)
+	}
+	return pulsar.DeserializeMessageID(data)
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFormatMessageID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   pulsar.MessageID
+		want string
+	}{
+		{
+			name: "it should format the message id",
+			id:   pulsar.EarliestMessageID(),
+			want: "-1:-1:-1:-1",
+		},
+		{
+			name: "it should return empty string for nil message id",
+			id:   nil,
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, FormatMessageID(tt.id))
+		})
+	}
+}
+
+func TestParseMessageID(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          string
+		want        string
+		expectError bool
+	}{
+		{
+			name: "it should parse the formatted message id",
+			id:   "12:34:2:-1",
+			want: "12:34:2:-1",
+		},
+		{
+			name: "it should parse the message id without the batch index",
+			id:   "12:34:-1",
+			want: "12:34:-1:-1",
+		},
+		{
+			name:        "it should return error for the message id which is not a number",
+			id:          "12:abc:0:0",
+			expectError: true,
+		},
+		{
+			name:        "it should return error for the message id without enough parts",
+			id:          "12:34",
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := ParseMessageID(tt.id)
+			assert.Equal(t, tt.expectError, err != nil)
+			if !tt.expectError {
+				assert.Equal(t, tt.want, FormatMessageID(id))
+			}
+		})
+	}
+}
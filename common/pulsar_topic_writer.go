@@ -31,6 +31,13 @@ func (writer *PulsarWriter) Write(p []byte) (int, error)  {
 	return len(p), nil
 }
 
+// Flush sends all the pending log messages
+func (writer *PulsarWriter) Flush() {
+	if writer != nil && writer.producer != nil {
+		_ = writer.producer.Flush()
+	}
+}
+
 func (writer *PulsarWriter) Close() {
 	if writer != nil && writer.producer != nil {
 		writer.producer.Close()
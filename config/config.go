@@ -0,0 +1,330 @@
+package config
+
+import (
+	"bash-runtime/runner"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// redacted replaces the secret values when the config is printed
+const redacted = "******"
+
+// Config is all the settings of the runtime. Every setting has a key in the config file, an environment and a
+// command-line flag, e.g. the key out_topic is set by OUT_TOPIC and --out-topic.
+// Fields tagged with secret:"true" are redacted when the config is printed, and the environments of the fields tagged
+// with private:"true" are not passed to the script.
+type Config struct {
+	PulsarURL string `yaml:"pulsar_url" usage:"the url of the pulsar service"`
+
+	AuthToken                  string `yaml:"auth_token" secret:"true" private:"true" usage:"the JWT token authenticating to pulsar"`
+	AuthTokenFile              string `yaml:"auth_token_file" private:"true" usage:"the file of the JWT token, it's reloaded when it changes"`
+	TLSTrustCertsFile          string `yaml:"tls_trust_certs_file" usage:"the CA bundle verifying the brokers"`
+	TLSCertFile                string `yaml:"tls_cert_file" private:"true" usage:"the client certificate of mTLS"`
+	TLSKeyFile                 string `yaml:"tls_key_file" private:"true" usage:"the private key of the client certificate"`
+	TLSAllowInsecureConnection bool   `yaml:"tls_allow_insecure_connection" usage:"accept the brokers whose certificates can't be verified"`
+	TLSValidateHostname        bool   `yaml:"tls_validate_hostname" usage:"check the hostname of the brokers matches their certificates"`
+	OAuth2IssuerURL            string `yaml:"oauth2_issuer_url" usage:"the issuer of the OAuth2 client credentials flow"`
+	OAuth2Audience             string `yaml:"oauth2_audience" usage:"the audience of the OAuth2 access token"`
+	OAuth2CredentialsFile      string `yaml:"oauth2_credentials_file" private:"true" usage:"the JSON file of the OAuth2 client_id and client_secret"`
+	OAuth2ClientID             string `yaml:"oauth2_client_id" usage:"the OAuth2 client id, the one in the credentials file by default"`
+	OAuth2Scope                string `yaml:"oauth2_scope" usage:"the OAuth2 scopes separated by spaces"`
+
+	InTopics                    []string      `yaml:"in_topics" usage:"the topics to consume, separated by commas"`
+	InTopicsPattern             string        `yaml:"in_topics_pattern" usage:"consume the topics matching the regex like persistent://tenant/ns/orders-.* instead of in_topics"`
+	TopicsDiscoveryPeriod       time.Duration `yaml:"topics_discovery_period" usage:"how often the topics matching in_topics_pattern are discovered, 0 for the pulsar default"`
+	Subscription                string        `yaml:"subscription" usage:"the subscription name"`
+	SubscriptionType            string        `yaml:"subscription_type" usage:"one of Exclusive, Failover, Shared and Key_Shared"`
+	SubscriptionInitialPosition string        `yaml:"subscription_initial_position" usage:"where a new subscription starts, one of earliest and latest"`
+	OutTopic                    string        `yaml:"out_topic" usage:"the topic the outputs are sent to"`
+	LogTopic                    string        `yaml:"log_topic" usage:"the topic the logs are sent to, empty to disable it"`
+	ReceiverQueueSize           int           `yaml:"receiver_queue_size" usage:"how many messages the consumer prefetches, 0 for the pulsar default"`
+
+	Script          string        `yaml:"script" usage:"the script processing the messages"`
+	InputMode       string        `yaml:"input_mode" usage:"how the payload is passed to the script, one of argv, stdin and file"`
+	OutputMode      string        `yaml:"output_mode" usage:"how the output is read from the script, one of raw, jsonl and fd"`
+	ExecMode        string        `yaml:"exec_mode" usage:"one of fork and worker"`
+	ScriptTimeout   time.Duration `yaml:"script_timeout" usage:"kill the script when it runs longer than it, 0 for no timeout"`
+	ScriptKillGrace time.Duration `yaml:"script_kill_grace" usage:"the time between SIGTERM and SIGKILL when killing the script"`
+	DrainTimeout    time.Duration `yaml:"drain_timeout" usage:"how long the in-flight scripts can keep running on shutdown"`
+	Concurrency     int           `yaml:"concurrency" usage:"the number of scripts running at the same time"`
+	KeyOrdering     bool          `yaml:"key_ordering" usage:"process the messages with a same key sequentially"`
+
+	FilterEmptyOutput bool   `yaml:"filter_empty_output" usage:"drop the message when the script has no output"`
+	FilterExitCode    int    `yaml:"filter_exit_code" usage:"the exit code dropping the message, 0 to disable it"`
+	ExitCodePolicy    string `yaml:"exit_code_policy" usage:"the actions of the exit codes like 2=drop,65=dead-letter"`
+
+	PropagateKey        bool     `yaml:"propagate_key" usage:"copy the key of the input message to the outputs"`
+	PropagateProperties []string `yaml:"propagate_properties" usage:"the properties copied to the outputs, separated by commas, * for all"`
+	PropagateEventTime  bool     `yaml:"propagate_event_time" usage:"copy the event time of the input message to the outputs"`
+	PropagateLineage    bool     `yaml:"propagate_lineage" usage:"add the source topic and message id to the outputs"`
+
+	NackRedeliveryDelay time.Duration `yaml:"nack_redelivery_delay" usage:"the delay before a failed message is redelivered"`
+	DLQTopic            string        `yaml:"dlq_topic" usage:"the dead letter topic, empty to retry the failed messages forever"`
+	RetryTopic          string        `yaml:"retry_topic" usage:"the retry letter topic, optional"`
+	MaxRedeliveries     int           `yaml:"max_redeliveries" usage:"how many times a message is redelivered before it's dead-lettered"`
+
+	BatchSize    int           `yaml:"batch_size" usage:"the max number of messages passed to the script at once"`
+	BatchTimeout time.Duration `yaml:"batch_timeout" usage:"how long a batch waits for more messages"`
+
+	WindowLengthCount     int           `yaml:"window_length_count" usage:"the number of messages of a count window"`
+	WindowSlideCount      int           `yaml:"window_slide_count" usage:"the number of messages a count window slides by"`
+	WindowLength          time.Duration `yaml:"window_length" usage:"the length of a time window"`
+	WindowSlide           time.Duration `yaml:"window_slide" usage:"the duration a time window slides by"`
+	WindowEventTime       bool          `yaml:"window_event_time" usage:"use the event time of the messages for the time windows"`
+	WindowAllowedLateness time.Duration `yaml:"window_allowed_lateness" usage:"how late a message can be in event time"`
+
+	HTTPAddress     string        `yaml:"http_address" usage:"the address serving /metrics, /healthz and /readyz, empty to disable it"`
+	LivenessTimeout time.Duration `yaml:"liveness_timeout" usage:"how long the runtime can make no progress with pending messages before /healthz fails, 0 to disable it"`
+
+	TraceExporter     string `yaml:"trace_exporter" usage:"where the spans are exported, one of none, otlp, stdout and file"`
+	TraceOTLPEndpoint string `yaml:"trace_otlp_endpoint" usage:"the url of the OTLP/HTTP receiver used by the otlp exporter, it overrides OTEL_EXPORTER_OTLP_ENDPOINT when it's set"`
+	TraceFile         string `yaml:"trace_file" usage:"the file the spans are appended to by the file exporter"`
+
+	LogFormat  string `yaml:"log_format" usage:"the format of the logs, one of text and json"`
+	LogLevel   string `yaml:"log_level" usage:"the lowest level logged, one of trace, debug, info, warn and error"`
+	LogPayload string `yaml:"log_payload" usage:"how the payloads are logged, one of off, truncated and full"`
+}
+
+// Default returns the config used when nothing is set
+func Default() Config {
+	return Config{
+		PulsarURL:                   "pulsar://localhost:6650",
+		TLSValidateHostname:         true,
+		InTopics:                    []string{"bash-runtime-in"},
+		Subscription:                "bash-runtime-sub",
+		SubscriptionType:            "Shared",
+		SubscriptionInitialPosition: "latest",
+		OutTopic:                    "bash-runtime-out",
+		LogTopic:                    "bash-runtime-log",
+		Script:                      "./scripts/exec.sh",
+		InputMode:                   "argv",
+		OutputMode:                  "raw",
+		ExecMode:                    "fork",
+		ScriptKillGrace:             3 * time.Second,
+		DrainTimeout:                5 * time.Second,
+		Concurrency:                 1,
+		FilterEmptyOutput:           true,
+		NackRedeliveryDelay:         time.Minute,
+		MaxRedeliveries:             3,
+		BatchSize:                   1,
+		BatchTimeout:                100 * time.Millisecond,
+		HTTPAddress:                 ":8080",
+		LivenessTimeout:             5 * time.Minute,
+		TraceExporter:               "none",
+		LogFormat:                   "text",
+		LogLevel:                    "info",
+		LogPayload:                  "off",
+	}
+}
+
+// Validate checks the settings which can be checked without connecting to pulsar, the modes and policies are
+// validated when the runner is created
+func (config *Config) Validate() error {
+	var problems []string
+	required := []struct {
+		key   string
+		value string
+	}{
+		{"pulsar_url", config.PulsarURL},
+		{"subscription", config.Subscription},
+		{"out_topic", config.OutTopic},
+		{"script", config.Script},
+	}
+	for _, setting := range required {
+		if strings.TrimSpace(setting.value) == "" {
+			problems = append(problems, describe(setting.key)+" must not be empty")
+		}
+	}
+	if len(config.InTopics) == 0 && config.InTopicsPattern == "" {
+		problems = append(problems, describe("in_topics")+" must not be empty unless "+describe("in_topics_pattern")+" is set")
+	}
+	if config.Concurrency < 1 {
+		problems = append(problems, describe("concurrency")+" must be at least 1")
+	}
+	if config.BatchSize < 1 {
+		problems = append(problems, describe("batch_size")+" must be at least 1")
+	}
+	if config.MaxRedeliveries < 0 {
+		problems = append(problems, describe("max_redeliveries")+" must not be negative")
+	}
+	if config.RetryTopic != "" && config.DLQTopic == "" {
+		problems = append(problems, describe("retry_topic")+" requires "+describe("dlq_topic"))
+	}
+	if config.RetryTopic != "" && config.MaxRedeliveries < 1 {
+		problems = append(problems, describe("max_redeliveries")+" must be at least 1 with "+describe("retry_topic"))
+	}
+	// the durations are checked in the order they're declared so that the message is stable
+	value := reflect.ValueOf(config).Elem()
+	for _, field := range fields() {
+		if duration, ok := value.Field(field.index).Interface().(time.Duration); ok && duration < 0 {
+			problems = append(problems, describe(field.key)+" must not be negative")
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// RunnerConfig converts the config to the one of the runner
+func (config *Config) RunnerConfig() runner.Config {
+	var deadLetterPolicy *runner.DeadLetterPolicy
+	if config.DLQTopic != "" {
+		deadLetterPolicy = &runner.DeadLetterPolicy{
+			MaxRedeliveries:  uint32(config.MaxRedeliveries),
+			DeadLetterTopic:  config.DLQTopic,
+			RetryLetterTopic: config.RetryTopic,
+		}
+	}
+	return runner.Config{
+		PulsarURL: config.PulsarURL,
+		Auth: runner.AuthConfig{
+			Token:                      config.AuthToken,
+			TokenFile:                  config.AuthTokenFile,
+			TLSTrustCertsFile:          config.TLSTrustCertsFile,
+			TLSCertFile:                config.TLSCertFile,
+			TLSKeyFile:                 config.TLSKeyFile,
+			TLSAllowInsecureConnection: config.TLSAllowInsecureConnection,
+			TLSValidateHostname:        config.TLSValidateHostname,
+			OAuth2: runner.OAuth2Config{
+				IssuerURL:       config.OAuth2IssuerURL,
+				Audience:        config.OAuth2Audience,
+				CredentialsFile: config.OAuth2CredentialsFile,
+				ClientID:        config.OAuth2ClientID,
+				Scope:           config.OAuth2Scope,
+			},
+		},
+		PrivateEnv:            privateEnv(),
+		LogTopic:              config.LogTopic,
+		InputTopics:           strings.Join(config.InTopics, ","),
+		TopicsPattern:         config.InTopicsPattern,
+		TopicsDiscoveryPeriod: config.TopicsDiscoveryPeriod,
+		Subscription:          config.Subscription,
+		SubscriptionType:      config.SubscriptionType,
+		InitialPosition:       config.SubscriptionInitialPosition,
+		OutputTopic:           config.OutTopic,
+		InputMode:             config.InputMode,
+		OutputMode:            config.OutputMode,
+		ExecMode:              config.ExecMode,
+		Propagation: runner.PropagationPolicy{
+			Key:        config.PropagateKey,
+			Properties: config.PropagateProperties,
+			EventTime:  config.PropagateEventTime,
+			Lineage:    config.PropagateLineage,
+		},
+		Filter: runner.FilterPolicy{
+			EmptyOutput: config.FilterEmptyOutput,
+			ExitCode:    config.FilterExitCode,
+		},
+		ExitCodePolicy:      config.ExitCodePolicy,
+		NackRedeliveryDelay: config.NackRedeliveryDelay,
+		DeadLetterPolicy:    deadLetterPolicy,
+		DrainTimeout:        config.DrainTimeout,
+		ScriptTimeout:       config.ScriptTimeout,
+		ScriptKillGrace:     config.ScriptKillGrace,
+		Concurrency:         config.Concurrency,
+		OrderByKey:          config.KeyOrdering,
+		Batch: runner.BatchPolicy{
+			MaxMessages: config.BatchSize,
+			MaxDelay:    config.BatchTimeout,
+		},
+		Window: runner.WindowPolicy{
+			LengthCount:     config.WindowLengthCount,
+			SlideCount:      config.WindowSlideCount,
+			Length:          config.WindowLength,
+			Slide:           config.WindowSlide,
+			EventTime:       config.WindowEventTime,
+			AllowedLateness: config.WindowAllowedLateness,
+		},
+		ReceiverQueueSize: config.ReceiverQueueSize,
+		LivenessTimeout:   config.LivenessTimeout,
+		Log:               config.LogConfig(),
+	}
+}
+
+// LogConfig returns how the logs are formatted
+func (config *Config) LogConfig() runner.LogConfig {
+	return runner.LogConfig{
+		Format:  config.LogFormat,
+		Level:   config.LogLevel,
+		Payload: config.LogPayload,
+	}
+}
+
+// TracingConfig returns where the spans are exported
+func (config *Config) TracingConfig() runner.TracingConfig {
+	return runner.TracingConfig{
+		Exporter:     config.TraceExporter,
+		OTLPEndpoint: config.TraceOTLPEndpoint,
+		File:         config.TraceFile,
+	}
+}
+
+// Redacted returns a copy of the config whose secrets are replaced, the secrets which are not set are kept empty
+func (config Config) Redacted() Config {
+	value := reflect.ValueOf(&config).Elem()
+	for _, field := range fields() {
+		if !field.secret {
+			continue
+		}
+		if fieldValue := value.Field(field.index); fieldValue.String() != "" {
+			fieldValue.SetString(redacted)
+		}
+	}
+	return config
+}
+
+// privateEnv returns the environments of the private settings
+func privateEnv() []string {
+	var names []string
+	for _, field := range fields() {
+		if field.private {
+			names = append(names, envName(field.key))
+		}
+	}
+	return names
+}
+
+// field describes a setting of Config
+type field struct {
+	index   int
+	key     string
+	usage   string
+	secret  bool
+	private bool
+}
+
+// fields returns the settings of Config in the order they're declared
+func fields() []field {
+	configType := reflect.TypeOf(Config{})
+	result := make([]field, 0, configType.NumField())
+	for i := 0; i < configType.NumField(); i++ {
+		structField := configType.Field(i)
+		result = append(result, field{
+			index:   i,
+			key:     structField.Tag.Get("yaml"),
+			usage:   structField.Tag.Get("usage"),
+			secret:  structField.Tag.Get("secret") == "true",
+			private: structField.Tag.Get("private") == "true",
+		})
+	}
+	return result
+}
+
+// envName returns the environment of the key, e.g. OUT_TOPIC for out_topic
+func envName(key string) string {
+	return strings.ToUpper(key)
+}
+
+// flagName returns the command-line flag of the key, e.g. out-topic for out_topic
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// describe names the setting in all the ways it can be set, so that the users know what to fix
+func describe(key string) string {
+	return fmt.Sprintf("%s (%s, --%s)", key, envName(key), flagName(key))
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"bash-runtime/runner"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		update      func(config *Config)
+		expectError string
+	}{
+		{
+			name:   "it should accept the defaults",
+			update: func(config *Config) {},
+		},
+		{
+			name: "it should reject the empty subscription and output topic",
+			update: func(config *Config) {
+				config.Subscription = ""
+				config.OutTopic = " "
+			},
+			expectError: "invalid config: subscription (SUBSCRIPTION, --subscription) must not be empty; " +
+				"out_topic (OUT_TOPIC, --out-topic) must not be empty",
+		},
+		{
+			name: "it should reject the empty input topics",
+			update: func(config *Config) {
+				config.InTopics = nil
+			},
+			expectError: "invalid config: in_topics (IN_TOPICS, --in-topics) must not be empty unless in_topics_pattern (IN_TOPICS_PATTERN, --in-topics-pattern) is set",
+		},
+		{
+			name: "it should accept the topics pattern without the input topics",
+			update: func(config *Config) {
+				config.InTopics = nil
+				config.InTopicsPattern = "persistent://public/default/orders-.*"
+			},
+		},
+		{
+			name: "it should reject the negative durations",
+			update: func(config *Config) {
+				config.ScriptTimeout = -time.Second
+			},
+			expectError: "invalid config: script_timeout (SCRIPT_TIMEOUT, --script-timeout) must not be negative",
+		},
+		{
+			name: "it should reject the retry topic without the dead letter topic",
+			update: func(config *Config) {
+				config.RetryTopic = "retry"
+			},
+			expectError: "invalid config: retry_topic (RETRY_TOPIC, --retry-topic) requires dlq_topic (DLQ_TOPIC, --dlq-topic)",
+		},
+		{
+			name: "it should reject no redelivery with the retry topic",
+			update: func(config *Config) {
+				config.DLQTopic = "dlq"
+				config.RetryTopic = "retry"
+				config.MaxRedeliveries = 0
+			},
+			expectError: "invalid config: max_redeliveries (MAX_REDELIVERIES, --max-redeliveries) must be at least 1 with retry_topic (RETRY_TOPIC, --retry-topic)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Default()
+			tt.update(&config)
+			err := config.Validate()
+			if tt.expectError == "" {
+				assert.Equal(t, nil, err)
+			} else {
+				assert.EqualError(t, err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestConfig_RunnerConfig(t *testing.T) {
+	config := Default()
+	config.InTopics = []string{"a", "b"}
+	config.DLQTopic = "dlq"
+	config.PropagateProperties = []string{"trace-id"}
+	config.LogFormat = "json"
+
+	runnerConfig := config.RunnerConfig()
+	assert.Equal(t, "a,b", runnerConfig.InputTopics)
+	assert.Equal(t, "dlq", runnerConfig.DeadLetterPolicy.DeadLetterTopic)
+	assert.Equal(t, uint32(3), runnerConfig.DeadLetterPolicy.MaxRedeliveries)
+	assert.Equal(t, []string{"trace-id"}, runnerConfig.Propagation.Properties)
+	assert.Equal(t, 100*time.Millisecond, runnerConfig.Batch.MaxDelay)
+	assert.Equal(t, runner.LogConfig{Format: "json", Level: "info", Payload: "off"}, runnerConfig.Log)
+	assert.Equal(t, []string{"AUTH_TOKEN", "AUTH_TOKEN_FILE", "TLS_CERT_FILE", "TLS_KEY_FILE", "OAUTH2_CREDENTIALS_FILE"},
+		runnerConfig.PrivateEnv)
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	config := Default()
+	config.AuthToken = "secret"
+	config.AuthTokenFile = "/var/run/secrets/token"
+
+	redactedConfig := config.Redacted()
+	assert.Equal(t, "******", redactedConfig.AuthToken)
+	assert.Equal(t, "/var/run/secrets/token", redactedConfig.AuthTokenFile)
+	// the config itself is not changed
+	assert.Equal(t, "secret", config.AuthToken)
+	// the secrets which are not set are kept empty
+	assert.Equal(t, "", Default().Redacted().AuthToken)
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Loader loads the config from the sources below, the later ones override the earlier ones:
+//  1. the defaults
+//  2. the config file given by --config, in YAML or JSON
+//  3. the environments, e.g. OUT_TOPIC
+//  4. the command-line flags, e.g. --out-topic
+type Loader struct {
+	file string
+	// flags are the raw values of the flags which are set
+	flags     map[string]string
+	lookupEnv func(key string) (string, bool)
+}
+
+// NewLoader registers --config and the flags of all the settings to the flag set, Load should be called after the
+// flags are parsed
+func NewLoader(flags *flag.FlagSet) *Loader {
+	loader := &Loader{
+		flags:     map[string]string{},
+		lookupEnv: os.LookupEnv,
+	}
+	flags.StringVar(&loader.file, "config", "", "the YAML or JSON config file")
+	defaults := reflect.ValueOf(Default())
+	for _, field := range fields() {
+		flags.Var(&flagValue{
+			loader:       loader,
+			key:          field.key,
+			valueType:    defaults.Field(field.index).Type(),
+			defaultValue: formatValue(defaults.Field(field.index)),
+		}, flagName(field.key), fmt.Sprintf("%s, env %s", field.usage, envName(field.key)))
+	}
+	return loader
+}
+
+// Load returns the validated config
+func (loader *Loader) Load() (Config, error) {
+	config := Default()
+	if loader.file != "" {
+		if err := loadFile(loader.file, &config); err != nil {
+			return config, err
+		}
+	}
+	value := reflect.ValueOf(&config).Elem()
+	for _, field := range fields() {
+		if raw, ok := loader.lookupEnv(envName(field.key)); ok {
+			if err := setValue(value.Field(field.index), raw); err != nil {
+				return config, fmt.Errorf("invalid %s '%s', %s", envName(field.key), raw, err)
+			}
+		}
+	}
+	for _, field := range fields() {
+		if raw, ok := loader.flags[field.key]; ok {
+			// the flags are checked when they're parsed
+			_ = setValue(value.Field(field.index), raw)
+		}
+	}
+	return config, config.Validate()
+}
+
+// loadFile reads the settings in the file over the config, the unknown keys are rejected to catch the typos.
+// JSON is read as YAML as it's a subset of YAML.
+func loadFile(path string, config *Config) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file, %s", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s, %s", path, err)
+	}
+	return nil
+}
+
+// Print writes the config in YAML with the secrets redacted, it can be used as a config file
+func Print(writer io.Writer, config Config) error {
+	value := reflect.ValueOf(config.Redacted())
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, field := range fields() {
+		fieldValue := value.Field(field.index)
+		var content interface{} = fieldValue.Interface()
+		if fieldValue.Type() == durationType {
+			content = formatValue(fieldValue)
+		}
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(content); err != nil {
+			return err
+		}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: field.key}, valueNode)
+	}
+	encoder := yaml.NewEncoder(writer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// setValue parses the raw value of an environment or a flag into the setting, lists are separated by commas.
+// An empty value doesn't change the numbers, durations and booleans.
+func setValue(value reflect.Value, raw string) error {
+	if raw == "" && value.Kind() != reflect.String && value.Kind() != reflect.Slice {
+		return nil
+	}
+	switch {
+	case value.Type() == durationType:
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+	case value.Kind() == reflect.String:
+		value.SetString(raw)
+	case value.Kind() == reflect.Int:
+		number, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(number))
+	case value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		value.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+// formatValue formats the setting the way setValue parses it
+func formatValue(value reflect.Value) string {
+	if value.Type() == durationType {
+		return time.Duration(value.Int()).String()
+	}
+	if value.Kind() == reflect.Slice {
+		return strings.Join(value.Interface().([]string), ",")
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// flagValue records the raw value of a setting flag, it's applied by Loader.Load after the file and environments
+type flagValue struct {
+	loader       *Loader
+	key          string
+	valueType    reflect.Type
+	defaultValue string
+}
+
+func (value *flagValue) String() string {
+	if value.loader == nil {
+		// called by the flag package on a zero value to find out whether the default is zero
+		return ""
+	}
+	if raw, ok := value.loader.flags[value.key]; ok {
+		return raw
+	}
+	return value.defaultValue
+}
+
+func (value *flagValue) Set(raw string) error {
+	if err := setValue(reflect.New(value.valueType).Elem(), raw); err != nil {
+		return err
+	}
+	value.loader.flags[value.key] = raw
+	return nil
+}
+
+// IsBoolFlag allows the boolean settings to be set by the flag name only, e.g. --key-ordering
+func (value *flagValue) IsBoolFlag() bool {
+	return value.valueType.Kind() == reflect.Bool
+}
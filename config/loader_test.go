@@ -0,0 +1,127 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoader_Load(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "config.yaml")
+	assert.Nil(t, ioutil.WriteFile(yamlFile, []byte("out_topic: file-out\nsubscription: file-sub\n"+
+		"in_topics: [a, b]\nscript_timeout: 5s\nconcurrency: 4\n"), 0644))
+	jsonFile := filepath.Join(dir, "config.json")
+	assert.Nil(t, ioutil.WriteFile(jsonFile, []byte(`{"out_topic": "json-out", "key_ordering": true}`), 0644))
+	unknownFile := filepath.Join(dir, "unknown.yaml")
+	assert.Nil(t, ioutil.WriteFile(unknownFile, []byte("out_topics: typo\n"), 0644))
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		check       func(t *testing.T, config Config)
+		expectError string
+	}{
+		{
+			name: "it should use the defaults when nothing is set",
+			check: func(t *testing.T, config Config) {
+				assert.Equal(t, Default(), config)
+			},
+		},
+		{
+			name: "it should read the config file",
+			args: []string{"--config", yamlFile},
+			check: func(t *testing.T, config Config) {
+				assert.Equal(t, "file-out", config.OutTopic)
+				assert.Equal(t, []string{"a", "b"}, config.InTopics)
+				assert.Equal(t, 5*time.Second, config.ScriptTimeout)
+				assert.Equal(t, 4, config.Concurrency)
+				assert.Equal(t, "./scripts/exec.sh", config.Script)
+			},
+		},
+		{
+			name: "it should read the config file in JSON",
+			args: []string{"--config", jsonFile},
+			check: func(t *testing.T, config Config) {
+				assert.Equal(t, "json-out", config.OutTopic)
+				assert.Equal(t, true, config.KeyOrdering)
+			},
+		},
+		{
+			name: "it should override the config file with the environments, and the environments with the flags",
+			env:  map[string]string{"OUT_TOPIC": "env-out", "SUBSCRIPTION": "env-sub", "IN_TOPICS": "c, d,"},
+			args: []string{"--config", yamlFile, "--subscription", "flag-sub", "--propagate-key"},
+			check: func(t *testing.T, config Config) {
+				assert.Equal(t, "env-out", config.OutTopic)
+				assert.Equal(t, "flag-sub", config.Subscription)
+				assert.Equal(t, []string{"c", "d"}, config.InTopics)
+				assert.Equal(t, 4, config.Concurrency)
+				assert.Equal(t, true, config.PropagateKey)
+			},
+		},
+		{
+			name:        "it should reject the unknown keys in the config file",
+			args:        []string{"--config", unknownFile},
+			expectError: "field out_topics not found",
+		},
+		{
+			name:        "it should reject the invalid environment",
+			env:         map[string]string{"DRAIN_TIMEOUT": "10"},
+			expectError: "invalid DRAIN_TIMEOUT '10'",
+		},
+		{
+			name:        "it should reject the empty output topic",
+			env:         map[string]string{"OUT_TOPIC": ""},
+			expectError: "out_topic (OUT_TOPIC, --out-topic) must not be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			loader := NewLoader(flags)
+			loader.lookupEnv = func(key string) (string, bool) {
+				value, ok := tt.env[key]
+				return value, ok
+			}
+			assert.Nil(t, flags.Parse(tt.args))
+			config, err := loader.Load()
+			if tt.expectError != "" {
+				assert.Contains(t, err.Error(), tt.expectError)
+				return
+			}
+			assert.Equal(t, nil, err)
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestLoader_InvalidFlag(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	NewLoader(flags)
+	err := flags.Parse([]string{"--concurrency", "many"})
+	assert.Contains(t, err.Error(), `invalid value "many" for flag -concurrency`)
+}
+
+func TestPrint(t *testing.T) {
+	config := Default()
+	config.InTopics = []string{"a", "b"}
+	config.PropagateProperties = []string{"trace-id"}
+	var buf bytes.Buffer
+	assert.Nil(t, Print(&buf, config))
+	assert.Contains(t, buf.String(), "in_topics:\n  - a\n  - b\n")
+	assert.Contains(t, buf.String(), "drain_timeout: 5s\n")
+
+	// the printed config can be loaded again
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(t, ioutil.WriteFile(file, buf.Bytes(), 0644))
+	loaded := Default()
+	assert.Nil(t, loadFile(file, &loaded))
+	assert.Equal(t, config, loaded)
+}
@@ -1,25 +1,169 @@
 package main
 
 import (
-	"bash-runtime/common"
+	"bash-runtime/config"
 	"bash-runtime/runner"
+	"context"
+	"flag"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	pulsarUrl := common.GetEnv("PULSAR_URL", "pulsar://localhost:6650")
-	outTopic := common.GetEnv("OUT_TOPIC", "bash-runtime-out")
-	logTopic := common.GetEnv("LOG_TOPIC", "bash-runtime-log")
-	inTopics := common.GetEnv("IN_TOPICS", "bash-runtime-in")
-	subscription := common.GetEnv("SUBSCRIPTION", "bash-runtime-sub")
-	script := common.GetEnv("SCRIPT", "./scripts/exec.sh")
-
-	scriptRunner, err := runner.NewRunner(pulsarUrl, logTopic, inTopics, subscription, outTopic)
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "":
+		os.Exit(serve(args))
+	case "invoke":
+		os.Exit(invoke(args))
+	case "replay":
+		os.Exit(replay(args))
+	case "config":
+		os.Exit(configCommand(args))
+	case "seek":
+		os.Exit(seek(args))
+	default:
+		logrus.Errorf("Unknown command '%s', the commands are invoke, replay, config and seek", command)
+		os.Exit(2)
+	}
+}
+
+// serve processes the messages from pulsar until it's stopped by SIGTERM/SIGINT
+func serve(args []string) int {
+	flags := flag.NewFlagSet("bash-runtime", flag.ContinueOnError)
+	loader := config.NewLoader(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		logrus.Errorf("Failed to load config: %s", err)
+		return 1
+	}
+
+	if err := runner.SetupLogging(cfg.LogConfig()); err != nil {
+		logrus.Errorf("Failed to set up logging: %s", err)
+		return 1
+	}
+
+	shutdownTracing, err := runner.SetupTracing(cfg.TracingConfig())
+	if err != nil {
+		logrus.Errorf("Failed to set up tracing: %s", err)
+		return 1
+	}
+	defer flushTraces(shutdownTracing)
+
+	// the probes are served before the runner is created, so that it's not ready until the runner is created
+	var status *statusServer
+	if cfg.HTTPAddress != "" {
+		status = startStatusServer(cfg.HTTPAddress)
+		defer status.close()
+	}
+
+	scriptRunner, err := runner.NewRunner(cfg.RunnerConfig())
 	if err != nil {
 		logrus.Errorf("Failed to initialize script runner: %s", err)
-		os.Exit(1)
+		return 1
 	}
 	defer scriptRunner.Close()
-	_ = scriptRunner.Run(script)
-}
\ No newline at end of file
+	status.setRunner(scriptRunner)
+
+	// stop receiving messages on SIGTERM/SIGINT, and wait for the in-flight script to finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	err = scriptRunner.Run(ctx, cfg.Script)
+	scriptRunner.Flush()
+	if err != nil {
+		// let the orchestrator restart the runtime
+		return 1
+	}
+	return 0
+}
+
+// flushTraces exports the remaining spans before exiting
+func flushTraces(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		logrus.Errorf("Failed to flush traces: %s", err)
+	}
+}
+
+// configCommand prints the effective config with the secrets redacted
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		logrus.Errorf("Unknown config command, the command is 'config print'")
+		return 2
+	}
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	loader := config.NewLoader(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		logrus.Errorf("Failed to load config: %s", err)
+		return 1
+	}
+	if err = config.Print(os.Stdout, cfg); err != nil {
+		logrus.Errorf("Failed to print config: %s", err)
+		return 1
+	}
+	return 0
+}
+
+// seek moves the subscription back to a message id or a time, so that the messages after it are processed again by
+// the runtime. The dry run only reports how many messages would be replayed.
+func seek(args []string) int {
+	flags := flag.NewFlagSet("seek", flag.ContinueOnError)
+	loader := config.NewLoader(flags)
+	messageID := flags.String("message-id", "", "the message id to seek to, like ledgerId:entryId:partitionIdx:batchIdx")
+	at := flags.String("time", "", "the publish time to seek to in RFC3339, or a duration before now like 24h")
+	dryRun := flags.Bool("dry-run", false, "count the messages which would be replayed without seeking")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		logrus.Errorf("Failed to load config: %s", err)
+		return 1
+	}
+	position := runner.SeekPosition{MessageID: *messageID}
+	if *at != "" {
+		if position.Time, err = parseSeekTime(*at, time.Now()); err != nil {
+			logrus.Errorf("Invalid time: %s", err)
+			return 2
+		}
+	}
+
+	count, err := runner.Seek(cfg.RunnerConfig(), position, *dryRun)
+	if err != nil {
+		logrus.Errorf("Failed to seek: %s", err)
+		return 1
+	}
+	if *dryRun {
+		logrus.Infof("%d messages would be replayed from %s", count, position)
+	}
+	return 0
+}
+
+// parseSeekTime parses the time in RFC3339, or the duration before now
+func parseSeekTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return time.Time{}, fmt.Errorf("'%s' is neither a time in RFC3339 nor a positive duration", value)
+	}
+	return now.Add(-duration), nil
+}
@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthConfig decides how the client authenticates to pulsar and verifies the brokers. At most one of the token,
+// the TLS client certificate and OAuth2 can be used, no authentication when none of them is set.
+type AuthConfig struct {
+	// Token is the JWT token, it can't be used with TokenFile
+	Token string
+	// TokenFile is the file of the JWT token, it's reloaded when the file changes
+	TokenFile string
+	// TLSTrustCertsFile is the CA bundle verifying the brokers, the system CAs are used when it's empty
+	TLSTrustCertsFile string
+	// TLSCertFile and TLSKeyFile are the client certificate of mTLS
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSAllowInsecureConnection accepts the brokers whose certificates can't be verified
+	TLSAllowInsecureConnection bool
+	// TLSValidateHostname checks the hostname of the brokers matches their certificates
+	TLSValidateHostname bool
+	// OAuth2 is used when its IssuerURL is set
+	OAuth2 OAuth2Config
+}
+
+// OAuth2Config authenticates with the OAuth2 client credentials flow
+type OAuth2Config struct {
+	IssuerURL string
+	Audience  string
+	// CredentialsFile is the JSON file of the client_id and client_secret
+	CredentialsFile string
+	// ClientID is optional, the one in the CredentialsFile is used when it's empty
+	ClientID string
+	// Scope is optional, scopes are separated by spaces
+	Scope string
+}
+
+func (config AuthConfig) validate() error {
+	var methods []string
+	if config.Token != "" || config.TokenFile != "" {
+		methods = append(methods, "token")
+	}
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		methods = append(methods, "tls")
+	}
+	if config.OAuth2.IssuerURL != "" {
+		methods = append(methods, "oauth2")
+	}
+	switch {
+	case len(methods) > 1:
+		return fmt.Errorf("only one of the authentications can be used, got %s", strings.Join(methods, " and "))
+	case config.Token != "" && config.TokenFile != "":
+		return errors.New("only one of the token and the token file can be set")
+	case (config.TLSCertFile == "") != (config.TLSKeyFile == ""):
+		return errors.New("both the TLS certificate file and key file are required by the client certificate")
+	case config.OAuth2.IssuerURL != "" && config.OAuth2.CredentialsFile == "":
+		return errors.New("the credentials file is required by OAuth2")
+	}
+	return nil
+}
+
+// applyTo sets the authentication and TLS settings of the client, the secrets are never put in the errors or logs
+func (config AuthConfig) applyTo(options *pulsar.ClientOptions, logger *logrus.Logger) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	options.TLSTrustCertsFilePath = config.TLSTrustCertsFile
+	options.TLSAllowInsecureConnection = config.TLSAllowInsecureConnection
+	options.TLSValidateHostname = config.TLSValidateHostname
+
+	switch {
+	case config.Token != "":
+		options.Authentication = pulsar.NewAuthenticationToken(config.Token)
+	case config.TokenFile != "":
+		supplier := newTokenFileSupplier(config.TokenFile, logger)
+		// read it once so that the missing file is reported at startup
+		if _, err := supplier.token(); err != nil {
+			return err
+		}
+		options.Authentication = pulsar.NewAuthenticationTokenFromSupplier(supplier.token)
+	case config.TLSCertFile != "":
+		options.Authentication = pulsar.NewAuthenticationTLS(config.TLSCertFile, config.TLSKeyFile)
+	case config.OAuth2.IssuerURL != "":
+		params, err := json.Marshal(map[string]string{
+			"type":       "client_credentials",
+			"issuerUrl":  config.OAuth2.IssuerURL,
+			"audience":   config.OAuth2.Audience,
+			"privateKey": config.OAuth2.CredentialsFile,
+			"clientId":   config.OAuth2.ClientID,
+			"scope":      config.OAuth2.Scope,
+		})
+		if err != nil {
+			return err
+		}
+		authentication, err := pulsar.NewAuthentication("oauth2", string(params))
+		if err != nil {
+			return fmt.Errorf("failed to authorize with OAuth2, %s", err)
+		}
+		options.Authentication = authentication
+	}
+	return nil
+}
+
+// tokenFileSupplier reads the token from the file, and reads it again when the file is modified, so that a rotated
+// token is used for the new connections
+type tokenFileSupplier struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	value   string
+	logger  *logrus.Logger
+}
+
+func newTokenFileSupplier(path string, logger *logrus.Logger) *tokenFileSupplier {
+	return &tokenFileSupplier{path: path, logger: logger}
+}
+
+// token returns the current token, the last one is kept when the file can't be read, e.g. when it's being replaced
+func (supplier *tokenFileSupplier) token() (string, error) {
+	supplier.mu.Lock()
+	defer supplier.mu.Unlock()
+	info, err := os.Stat(supplier.path)
+	if err != nil {
+		return supplier.fallback(err)
+	}
+	if supplier.value != "" && info.ModTime().Equal(supplier.modTime) {
+		return supplier.value, nil
+	}
+	content, err := ioutil.ReadFile(supplier.path)
+	if err != nil {
+		return supplier.fallback(err)
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "" {
+		return supplier.fallback(errors.New("the token file is empty"))
+	}
+	if supplier.value != "" {
+		supplier.logger.Infof("token file %s is changed, reload it", supplier.path)
+	}
+	supplier.value = value
+	supplier.modTime = info.ModTime()
+	return value, nil
+}
+
+func (supplier *tokenFileSupplier) fallback(err error) (string, error) {
+	if supplier.value == "" {
+		return "", fmt.Errorf("failed to read token file %s, %s", supplier.path, err)
+	}
+	supplier.logger.Warnf("failed to read token file %s, keep using the last token, %s", supplier.path, err)
+	return supplier.value, nil
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      AuthConfig
+		expectError string
+	}{
+		{
+			name:   "it should accept no authentication",
+			config: AuthConfig{TLSTrustCertsFile: "ca.pem"},
+		},
+		{
+			name:   "it should accept the client certificate",
+			config: AuthConfig{TLSCertFile: "client.pem", TLSKeyFile: "client-key.pem"},
+		},
+		{
+			name:        "it should reject the token with the client certificate",
+			config:      AuthConfig{Token: "secret", TLSCertFile: "client.pem", TLSKeyFile: "client-key.pem"},
+			expectError: "only one of the authentications can be used, got token and tls",
+		},
+		{
+			name:        "it should reject both the token and the token file",
+			config:      AuthConfig{Token: "secret", TokenFile: "token"},
+			expectError: "only one of the token and the token file can be set",
+		},
+		{
+			name:        "it should reject the client certificate without the key",
+			config:      AuthConfig{TLSCertFile: "client.pem"},
+			expectError: "both the TLS certificate file and key file are required by the client certificate",
+		},
+		{
+			name:        "it should reject OAuth2 without the credentials file",
+			config:      AuthConfig{OAuth2: OAuth2Config{IssuerURL: "https://auth.example.com"}},
+			expectError: "the credentials file is required by OAuth2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if tt.expectError == "" {
+				assert.Equal(t, nil, err)
+			} else {
+				assert.EqualError(t, err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestAuthConfig_ApplyTo(t *testing.T) {
+	options := pulsar.ClientOptions{}
+	err := AuthConfig{
+		Token:               "secret",
+		TLSTrustCertsFile:   "ca.pem",
+		TLSValidateHostname: true,
+	}.applyTo(&options, logrus.New())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ca.pem", options.TLSTrustCertsFilePath)
+	assert.Equal(t, true, options.TLSValidateHostname)
+	assert.NotNil(t, options.Authentication)
+
+	// the missing token file is reported without its content
+	options = pulsar.ClientOptions{}
+	err = AuthConfig{TokenFile: filepath.Join(t.TempDir(), "token")}.applyTo(&options, logrus.New())
+	assert.Contains(t, err.Error(), "failed to read token file")
+	assert.Nil(t, options.Authentication)
+}
+
+func TestTokenFileSupplier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("first\n"), 0600))
+	supplier := newTokenFileSupplier(path, logrus.New())
+
+	token, err := supplier.token()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "first", token)
+
+	// the rotated token is read when the file is modified
+	assert.Nil(t, ioutil.WriteFile(path, []byte("second"), 0600))
+	later := time.Now().Add(time.Second)
+	assert.Nil(t, os.Chtimes(path, later, later))
+	token, err = supplier.token()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "second", token)
+
+	// the last token is kept while the file is being replaced
+	assert.Nil(t, os.Remove(path))
+	token, err = supplier.token()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "second", token)
+}
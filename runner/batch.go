@@ -0,0 +1,218 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// EnvBatchSize tells the script how many messages are in the batch
+const EnvBatchSize = "PULSAR_BATCH_SIZE"
+
+const defaultBatchDelay = 100 * time.Millisecond
+
+// BatchPolicy groups the messages so that the script runs once for all of them
+type BatchPolicy struct {
+	// MaxMessages is the max number of messages in a batch, batching is disabled when it's less than 2
+	MaxMessages int
+	// MaxDelay is how long a batch waits for more messages after its first one, 100ms is used when it's zero
+	MaxDelay time.Duration
+}
+
+func (policy BatchPolicy) enabled() bool {
+	return policy.MaxMessages > 1
+}
+
+func (policy BatchPolicy) maxDelay() time.Duration {
+	if policy.MaxDelay <= 0 {
+		return defaultBatchDelay
+	}
+	return policy.MaxDelay
+}
+
+// validateBatchModes checks the modes which can be used in batch mode
+func validateBatchModes(inputMode InputMode, outputMode OutputMode, execMode ExecMode) error {
+	if inputMode != InputModeStdin && inputMode != InputModeFile {
+		return fmt.Errorf("input mode should be stdin or file in batch mode, got %s", inputMode)
+	}
+	if outputMode != OutputModeJSONL && outputMode != OutputModeFD {
+		return fmt.Errorf("output mode should be jsonl or fd in batch mode, got %s", outputMode)
+	}
+	if execMode != ExecModeFork {
+		return fmt.Errorf("exec mode should be fork in batch mode, got %s", execMode)
+	}
+	return nil
+}
+
+// batchRecord is an input message passed to the script in batch mode
+type batchRecord struct {
+	// Index is the position of the message in the batch, the output records refer to the message by it
+	Index           int               `json:"index"`
+	ID              string            `json:"id"`
+	Topic           string            `json:"topic"`
+	Key             string            `json:"key"`
+	Properties      map[string]string `json:"properties"`
+	PublishTime     string            `json:"publish_time"`
+	EventTime       string            `json:"event_time"`
+	RedeliveryCount uint32            `json:"redelivery_count"`
+	// Payload is set when the payload is valid UTF-8, otherwise PayloadB64 is set
+	Payload    *string `json:"payload,omitempty"`
+	PayloadB64 *string `json:"payload_b64,omitempty"`
+}
+
+// newBatchWorkerPool starts the workers which collect the messages from their channel into batches by the policy
+func newBatchWorkerPool(concurrency int, orderByKey bool, queueSize int, policy BatchPolicy,
+	handle func([]Message)) *workerPool {
+	return startWorkerPool(concurrency, orderByKey, queueSize, func(ch <-chan Message) {
+		collectBatches(ch, policy, handle)
+	})
+}
+
+// collectBatches handles a batch when it's full or its first message has waited for MaxDelay, the last batch is
+// handled when the channel is closed
+func collectBatches(ch <-chan Message, policy BatchPolicy, handle func([]Message)) {
+	for msg := range ch {
+		batch := []Message{msg}
+		timer := time.NewTimer(policy.maxDelay())
+	collect:
+		for len(batch) < policy.MaxMessages {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, msg)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		handle(batch)
+	}
+}
+
+// batchInput encodes the messages as one JSON record per line in the stdin input mode, or as a JSON array in the
+// file input mode
+func batchInput(msgs []Message, mode InputMode) ([]byte, error) {
+	records := make([]batchRecord, 0, len(msgs))
+	for i, msg := range msgs {
+		record := batchRecord{
+			Index:           i,
+			ID:              msg.ID(),
+			Topic:           sourceTopic(msg),
+			Key:             msg.Key(),
+			Properties:      msg.Properties(),
+			PublishTime:     formatTime(msg.PublishTime()),
+			EventTime:       formatTime(msg.EventTime()),
+			RedeliveryCount: msg.RedeliveryCount(),
+		}
+		if payload := msg.Payload(); utf8.Valid(payload) {
+			text := string(payload)
+			record.Payload = &text
+		} else {
+			encoded := base64.StdEncoding.EncodeToString(payload)
+			record.PayloadB64 = &encoded
+		}
+		records = append(records, record)
+	}
+
+	if mode == InputModeFile {
+		return json.Marshal(records)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// processBatch runs the script once with the messages, and handles every message by the output records referring
+// to it, a message without any output record is filtered
+func (runner *Runner) processBatch(ctx context.Context, scriptFile string, msgs []Message) {
+	input, err := batchInput(msgs, runner.execOptions.inputMode)
+	if err != nil {
+		runner.logFailure(msgs, nil, "failed to encode batch: %s", err)
+		runner.failBatch(msgs, fmt.Errorf("%w: %s", common.ErrScriptExecError, err), nil)
+		return
+	}
+	env := []string{EnvBatchSize + "=" + strconv.Itoa(len(msgs))}
+	start := time.Now()
+	stdout, stderr, err := runner.runScript(msgs, func(traceEnv []string) ([]byte, []byte, error) {
+		return execScript(ctx, scriptFile, input, append(env, traceEnv...), runner.execOptions)
+	})
+	duration := time.Since(start)
+	if runner.filterPolicy.filtersExit(err) {
+		for _, msg := range msgs {
+			runner.filter(msg, "exit code")
+		}
+		return
+	}
+	if err != nil {
+		runner.logFailure(msgs, scriptFields(err, duration), "failed to process batch of %d messages: %s", len(msgs), err)
+		if len(stderr) > 0 {
+			runner.logger.Errorf("error: %s", stderr)
+		}
+		if ctx.Err() != nil {
+			// the script is killed because of shutdown, it's not the messages' fault
+			for _, msg := range msgs {
+				runner.source.Nack(msg)
+			}
+			return
+		}
+		runner.failBatch(msgs, err, stderr)
+		return
+	}
+
+	if len(stderr) > 0 {
+		runner.logger.Errorf("error: %s", stderr)
+	}
+	outputs, err := parseOutput(stdout, runner.execOptions.outputMode)
+	if err != nil {
+		runner.logFailure(msgs, scriptFields(nil, duration), "failed to process batch of %d messages: %s", len(msgs), err)
+		runner.failBatch(msgs, err, stderr)
+		return
+	}
+	grouped := make([][]output, len(msgs))
+	failures := make([]error, len(msgs))
+	for _, out := range outputs {
+		if out.index < 0 || out.index >= len(msgs) {
+			err = fmt.Errorf("%w: index %d is out of the batch of %d messages", common.ErrInvalidOutput, out.index, len(msgs))
+			runner.logFailure(msgs, scriptFields(nil, duration), "failed to process batch: %s", err)
+			runner.failBatch(msgs, err, stderr)
+			return
+		}
+		if out.err != "" {
+			failures[out.index] = fmt.Errorf("%w: %s", common.ErrScriptExecError, out.err)
+			continue
+		}
+		grouped[out.index] = append(grouped[out.index], out)
+	}
+
+	for i, msg := range msgs {
+		switch {
+		case failures[i] != nil:
+			runner.messageLog(msg).WithFields(scriptFields(nil, duration)).Errorf("failed to process message: %s", failures[i])
+			runner.fail(msg, failures[i], stderr)
+		case len(grouped[i]) == 0:
+			runner.filter(msg, "empty output")
+		default:
+			runner.publish(runner.messageLog(msg).WithFields(scriptFields(nil, duration)), msg, grouped[i])
+		}
+	}
+}
+
+// failBatch handles every message of the batch as a failed one
+func (runner *Runner) failBatch(msgs []Message, cause error, stderr []byte) {
+	for _, msg := range msgs {
+		runner.fail(msg, cause, stderr)
+	}
+}
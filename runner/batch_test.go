@@ -0,0 +1,195 @@
+package runner
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCollectBatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      BatchPolicy
+		messages    int
+		closeAfter  time.Duration
+		expectSizes []int
+	}{
+		{
+			name:        "it should handle a batch once it's full",
+			policy:      BatchPolicy{MaxMessages: 2, MaxDelay: time.Minute},
+			messages:    4,
+			expectSizes: []int{2, 2},
+		},
+		{
+			name:        "it should handle the last batch when the channel is closed",
+			policy:      BatchPolicy{MaxMessages: 3, MaxDelay: time.Minute},
+			messages:    4,
+			expectSizes: []int{3, 1},
+		},
+		{
+			name:        "it should handle a batch after the max delay",
+			policy:      BatchPolicy{MaxMessages: 10, MaxDelay: 50 * time.Millisecond},
+			messages:    2,
+			closeAfter:  500 * time.Millisecond,
+			expectSizes: []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan Message, tt.messages)
+			for i := 0; i < tt.messages; i++ {
+				ch <- &mockMessage{}
+			}
+			handled := make(chan int, tt.messages)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				collectBatches(ch, tt.policy, func(msgs []Message) {
+					handled <- len(msgs)
+				})
+			}()
+
+			if tt.closeAfter > 0 {
+				// the batch should be handled before the channel is closed
+				select {
+				case size := <-handled:
+					assert.Equal(t, tt.expectSizes[0], size)
+					tt.expectSizes = tt.expectSizes[1:]
+				case <-time.After(tt.closeAfter):
+					t.Error("batch is not handled after the max delay")
+				}
+			}
+			close(ch)
+			<-done
+			close(handled)
+			var sizes []int
+			for size := range handled {
+				sizes = append(sizes, size)
+			}
+			if len(tt.expectSizes) == 0 {
+				tt.expectSizes = nil
+			}
+			assert.Equal(t, tt.expectSizes, sizes)
+		})
+	}
+}
+
+func TestBatchInput(t *testing.T) {
+	msgs := []Message{
+		&mockMessage{id: "10:1:-1:-1", payload: []byte("hello"), topic: "in", key: "k1"},
+		&mockMessage{id: "10:2:-1:-1", payload: []byte{0xff}, topic: "in", properties: map[string]string{"a": "b"}},
+	}
+
+	stdin, err := batchInput(msgs, InputModeStdin)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"index":0,"id":"10:1:-1:-1","topic":"in","key":"k1","properties":null,"publish_time":"","event_time":"","redelivery_count":0,"payload":"hello"}`+"\n"+
+		`{"index":1,"id":"10:2:-1:-1","topic":"in","key":"","properties":{"a":"b"},"publish_time":"","event_time":"","redelivery_count":0,"payload_b64":"/w=="}`+"\n",
+		string(stdin))
+
+	file, err := batchInput(msgs[:1], InputModeFile)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `[{"index":0,"id":"10:1:-1:-1","topic":"in","key":"k1","properties":null,"publish_time":"","event_time":"","redelivery_count":0,"payload":"hello"}]`,
+		string(file))
+}
+
+func TestValidateBatchModes(t *testing.T) {
+	assert.Nil(t, validateBatchModes(InputModeStdin, OutputModeJSONL, ExecModeFork))
+	assert.Nil(t, validateBatchModes(InputModeFile, OutputModeFD, ExecModeFork))
+	assert.NotNil(t, validateBatchModes(InputModeArgv, OutputModeJSONL, ExecModeFork))
+	assert.NotNil(t, validateBatchModes(InputModeStdin, OutputModeRaw, ExecModeFork))
+	assert.NotNil(t, validateBatchModes(InputModeStdin, OutputModeJSONL, ExecModeWorker))
+}
+
+func TestRunner_ProcessBatch(t *testing.T) {
+	requireJQ(t)
+	tests := []struct {
+		name         string
+		script       string
+		inputMode    InputMode
+		payloads     []string
+		expectAcks   int
+		expectNacks  int
+		expectOutput []string
+	}{
+		{
+			name:         "it should ack every message of the batch after its output is sent",
+			script:       "../scripts/batch.sh",
+			inputMode:    InputModeStdin,
+			payloads:     []string{"a", "b", "c"},
+			expectAcks:   3,
+			expectOutput: []string{"a!", "b!", "c!"},
+		},
+		{
+			name:         "it should read the batch from the file in file input mode",
+			script:       "../scripts/batch-file.sh",
+			inputMode:    InputModeFile,
+			payloads:     []string{"a", "b"},
+			expectAcks:   2,
+			expectOutput: []string{"a!", "b!"},
+		},
+		{
+			name:         "it should fail the messages with error records and filter the ones without output",
+			script:       "../scripts/batch.sh",
+			inputMode:    InputModeStdin,
+			payloads:     []string{"a", "fail", "skip"},
+			expectAcks:   2,
+			expectNacks:  1,
+			expectOutput: []string{"a!"},
+		},
+		{
+			name:        "it should fail the whole batch when the script fails",
+			script:      "../scripts/exit.sh",
+			inputMode:   InputModeStdin,
+			payloads:    []string{"a", "b"},
+			expectNacks: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msgs []Message
+			for _, payload := range tt.payloads {
+				msgs = append(msgs, &mockMessage{payload: []byte(payload)})
+			}
+			source := newMemorySource()
+			sink := NewMemorySink()
+			runner := &Runner{
+				source:      source,
+				sink:        sink,
+				logger:      logrus.New(),
+				execOptions: execOptions{inputMode: tt.inputMode, outputMode: OutputModeJSONL},
+			}
+
+			runner.processBatch(context.Background(), tt.script, msgs)
+			assert.Equal(t, tt.expectAcks, len(source.Acked()))
+			assert.Equal(t, tt.expectNacks, len(source.Nacked()))
+			var output []string
+			for _, sent := range sink.Sent("") {
+				output = append(output, string(sent.Payload))
+			}
+			assert.Equal(t, tt.expectOutput, output)
+		})
+	}
+}
+
+func TestRunner_RunBatch(t *testing.T) {
+	requireJQ(t)
+	source := newMemorySource(&mockMessage{payload: []byte("a")}, &mockMessage{payload: []byte("b")},
+		&mockMessage{payload: []byte("c")})
+	sink := NewMemorySink()
+	runner := &Runner{
+		source:      source,
+		sink:        sink,
+		logger:      logrus.New(),
+		execOptions: execOptions{inputMode: InputModeStdin, outputMode: OutputModeJSONL},
+		batch:       BatchPolicy{MaxMessages: 2, MaxDelay: time.Minute},
+	}
+
+	err := runner.Run(context.Background(), "../scripts/batch.sh")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(source.Acked()))
+	assert.Equal(t, 3, len(sink.Sent("")))
+}
@@ -0,0 +1,371 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// ExecMode decides how the script is started for the messages
+type ExecMode string
+
+const (
+	// ExecModeFork starts a new process of the script for every message
+	ExecModeFork ExecMode = "fork"
+	// ExecModeWorker starts the script once as a coprocess, and exchanges the messages with it over stdin and stdout,
+	// one JSON object per line
+	ExecModeWorker ExecMode = "worker"
+)
+
+// parseExecMode parses the exec mode name, fork is used when it's empty
+func parseExecMode(name string) (ExecMode, error) {
+	switch mode := ExecMode(strings.ToLower(name)); mode {
+	case "":
+		return ExecModeFork, nil
+	case ExecModeFork, ExecModeWorker:
+		return mode, nil
+	default:
+		return ExecModeFork, fmt.Errorf("unknown exec mode '%s', should be one of fork and worker", name)
+	}
+}
+
+// maxStderrLine is how many bytes of a stderr line of the worker are logged, the rest of the line is dropped
+const maxStderrLine = 64 * 1024
+
+// workerRequest is the line written to the stdin of the worker for a message
+type workerRequest struct {
+	ID string `json:"id"`
+	// Payload is set when the payload is valid UTF-8, otherwise PayloadB64 is set
+	Payload    *string           `json:"payload,omitempty"`
+	PayloadB64 *string           `json:"payload_b64,omitempty"`
+	Env        map[string]string `json:"env"`
+}
+
+// workerResponse is the line written to the stdout by the worker for a request
+type workerResponse struct {
+	// ID must be the same as the one of the request
+	ID       string `json:"id"`
+	ExitCode int    `json:"exit_code"`
+	// Output is read like the stdout of the script in the raw and jsonl output modes,
+	// only one of Output and OutputB64 can be set
+	Output    *string `json:"output"`
+	OutputB64 *string `json:"output_b64"`
+	Stderr    string  `json:"stderr"`
+}
+
+// coprocess is a running worker script, it handles one request at a time
+type coprocess struct {
+	pid       int
+	killGrace time.Duration
+	stdin     io.WriteCloser
+	// responses receives the lines of stdout, it's closed when the stdout is closed
+	responses chan []byte
+	// exited is closed after the worker exits
+	exited chan struct{}
+	seq    uint64
+}
+
+// startCoprocess starts the worker script in its own process group, its stderr is logged line by line
+func startCoprocess(file string, environ []string, killGrace time.Duration, logger *logrus.Logger) (*coprocess, error) {
+	if _, err := exec.LookPath(file); err != nil {
+		return nil, common.ErrScriptNotExist
+	}
+	cmd := exec.Command(file)
+	cmd.Env = environ
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", common.ErrScriptExecError, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", common.ErrScriptExecError, err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", common.ErrScriptExecError, err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %s", common.ErrScriptExecError, err)
+	}
+
+	worker := &coprocess{
+		pid:       cmd.Process.Pid,
+		killGrace: killGrace,
+		stdin:     stdin,
+		responses: make(chan []byte),
+		exited:    make(chan struct{}),
+	}
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		defer close(worker.responses)
+		reader := bufio.NewReader(stdout)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				worker.responses <- line
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer readers.Done()
+		err := readLines(stderr, maxStderrLine, func(line []byte) {
+			logger.Errorf("worker error: %s", line)
+		})
+		if err != nil {
+			logger.Errorf("failed to read worker stderr: %s", err)
+			// keep draining it so that the worker never blocks on writing its stderr
+			_, _ = io.Copy(io.Discard, stderr)
+		}
+	}()
+	go func() {
+		// the pipes must be read to the end before waiting for the worker
+		readers.Wait()
+		if err := cmd.Wait(); err != nil {
+			logger.Warnf("worker %d exited: %s", worker.pid, err)
+		}
+		close(worker.exited)
+	}()
+	return worker, nil
+}
+
+// readLines calls handle with every line of the reader until it ends, the lines longer than limit are truncated to
+// it instead of failing the read. The error is nil when the reader ends with io.EOF.
+func readLines(r io.Reader, limit int, handle func(line []byte)) error {
+	reader := bufio.NewReader(r)
+	var line []byte
+	for {
+		fragment, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			if len(line) > 0 {
+				handle(line)
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if room := limit - len(line); room > 0 {
+			if len(fragment) > room {
+				fragment = fragment[:room]
+			}
+			line = append(line, fragment...)
+		}
+		if !isPrefix {
+			handle(line)
+			line = line[:0]
+		}
+	}
+}
+
+// alive tells whether the worker is still running
+func (worker *coprocess) alive() bool {
+	select {
+	case <-worker.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// call sends the payload to the worker and waits for its response, the worker is killed when the call times out or
+// ctx is done, or when it breaks the protocol
+func (worker *coprocess) call(ctx context.Context, payload []byte, env []string, timeout time.Duration) ([]byte, []byte, error) {
+	scriptCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		scriptCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	worker.seq++
+	request := workerRequest{ID: strconv.FormatUint(worker.seq, 10), Env: map[string]string{}}
+	if utf8.Valid(payload) {
+		text := string(payload)
+		request.Payload = &text
+	} else {
+		encoded := base64.StdEncoding.EncodeToString(payload)
+		request.PayloadB64 = &encoded
+	}
+	for _, variable := range env {
+		if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 {
+			request.Env[parts[0]] = parts[1]
+		}
+	}
+	line, err := json.Marshal(request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", common.ErrScriptExecError, err)
+	}
+
+	type result struct {
+		response workerResponse
+		err      error
+	}
+	// the worker may block on writing or reading, exchange in a goroutine so that it can be killed on timeout
+	results := make(chan result, 1)
+	go func() {
+		if _, err := worker.stdin.Write(append(line, '\n')); err != nil {
+			results <- result{err: fmt.Errorf("worker exited: %s", err)}
+			return
+		}
+		data, ok := <-worker.responses
+		if !ok {
+			results <- result{err: fmt.Errorf("worker exited")}
+			return
+		}
+		var response workerResponse
+		if err := json.Unmarshal(data, &response); err != nil {
+			results <- result{err: fmt.Errorf("invalid response: %s", err)}
+			return
+		}
+		results <- result{response: response}
+	}()
+
+	var res result
+	select {
+	case res = <-results:
+	case <-scriptCtx.Done():
+		worker.kill()
+		if ctx.Err() == nil && scriptCtx.Err() == context.DeadlineExceeded {
+			return nil, nil, common.ErrScriptTimeout
+		}
+		return nil, nil, common.ErrScriptExecError
+	}
+	if res.err == nil && res.response.ID != request.ID {
+		res.err = fmt.Errorf("response id '%s' doesn't match the request id '%s'", res.response.ID, request.ID)
+	}
+	if res.err == nil && res.response.Output != nil && res.response.OutputB64 != nil {
+		res.err = fmt.Errorf("both output and output_b64 are set")
+	}
+	if res.err != nil {
+		// the worker can't be trusted anymore, it's restarted for the next message
+		worker.kill()
+		return nil, nil, fmt.Errorf("%w: %s", common.ErrScriptExecError, res.err)
+	}
+
+	response := res.response
+	var stdout []byte
+	if response.OutputB64 != nil {
+		stdout, err = base64.StdEncoding.DecodeString(*response.OutputB64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: invalid output_b64: %s", common.ErrInvalidOutput, err)
+		}
+	} else if response.Output != nil {
+		stdout = []byte(*response.Output)
+	}
+	stdout = bytes.TrimRight(stdout, "\n")
+	stderr := bytes.TrimRight([]byte(response.Stderr), "\n")
+	if response.ExitCode != 0 {
+		return stdout, stderr, &common.ScriptExitError{ExitCode: response.ExitCode}
+	}
+	return stdout, stderr, nil
+}
+
+// kill kills the process group of the worker, it returns after the worker exits
+func (worker *coprocess) kill() {
+	_ = worker.stdin.Close()
+	killProcessGroup(worker.pid, worker.killGrace, worker.exited)
+	// drop the lines not read yet, so that the stdout reader can finish
+	for range worker.responses {
+	}
+	<-worker.exited
+}
+
+// close closes the stdin of the worker so that it can exit by itself, and kills it if it doesn't in the kill grace
+func (worker *coprocess) close() {
+	_ = worker.stdin.Close()
+	killGrace := worker.killGrace
+	if killGrace <= 0 {
+		killGrace = defaultKillGrace
+	}
+	timer := time.NewTimer(killGrace)
+	defer timer.Stop()
+	select {
+	case <-worker.exited:
+	case <-timer.C:
+		worker.kill()
+	}
+}
+
+// coprocessPool keeps a fixed number of workers, a worker is started when it's used for the first time, and restarted
+// when it's found exited
+type coprocessPool struct {
+	// number of the restarts of the workers, accessed atomically, keep it first to be 64-bit aligned
+	restarts  uint64
+	file      string
+	killGrace time.Duration
+	logger    *logrus.Logger
+	// restarted is called when a worker is restarted, it's optional
+	restarted func()
+	// privateEnv holds the environments which are not passed to the workers, it's optional
+	privateEnv map[string]bool
+	// idle holds the workers which are not handling messages, nil means the worker is not started yet
+	idle chan *coprocess
+}
+
+func newCoprocessPool(file string, size int, killGrace time.Duration, logger *logrus.Logger) *coprocessPool {
+	if size < 1 {
+		size = 1
+	}
+	idle := make(chan *coprocess, size)
+	for i := 0; i < size; i++ {
+		idle <- nil
+	}
+	return &coprocessPool{
+		file:      file,
+		killGrace: killGrace,
+		logger:    logger,
+		idle:      idle,
+	}
+}
+
+// call handles the payload with an idle worker, it blocks until there is one
+func (pool *coprocessPool) call(ctx context.Context, payload []byte, env []string, timeout time.Duration) ([]byte, []byte, error) {
+	worker := <-pool.idle
+	defer func() {
+		pool.idle <- worker
+	}()
+	if worker == nil || !worker.alive() {
+		if worker != nil {
+			atomic.AddUint64(&pool.restarts, 1)
+			if pool.restarted != nil {
+				pool.restarted()
+			}
+			pool.logger.Warnf("worker %d exited, restart it", worker.pid)
+		}
+		var err error
+		worker, err = startCoprocess(pool.file, scriptEnviron(pool.privateEnv), pool.killGrace, pool.logger)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return worker.call(ctx, payload, env, timeout)
+}
+
+// close stops all the workers, it waits for the in-flight calls to finish
+func (pool *coprocessPool) close() {
+	for i := 0; i < cap(pool.idle); i++ {
+		if worker := <-pool.idle; worker != nil {
+			worker.close()
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func requireJQ(tb testing.TB) {
+	if _, err := exec.LookPath("jq"); err != nil {
+		tb.Skip("jq is required by the worker script")
+	}
+}
+
+func TestParseExecMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		expectMode  ExecMode
+		expectError bool
+	}{
+		{
+			name:       "it should use fork by default",
+			mode:       "",
+			expectMode: ExecModeFork,
+		},
+		{
+			name:       "it should parse the worker mode case-insensitively",
+			mode:       "Worker",
+			expectMode: ExecModeWorker,
+		},
+		{
+			name:        "it should return error for unknown mode",
+			mode:        "thread",
+			expectMode:  ExecModeFork,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, err := parseExecMode(tt.mode)
+			assert.Equal(t, tt.expectMode, mode)
+			assert.Equal(t, tt.expectError, err != nil)
+		})
+	}
+}
+
+func TestCoprocessPool_Call(t *testing.T) {
+	requireJQ(t)
+	tests := []struct {
+		name         string
+		script       string
+		payload      string
+		timeout      time.Duration
+		expectStdout string
+		expectStderr string
+		expectError  error
+	}{
+		{
+			name:         "it should get the output from the worker",
+			script:       "../scripts/worker.sh",
+			payload:      "hello world",
+			expectStdout: "hello world!",
+			expectError:  nil,
+		},
+		{
+			name:         "it should return the exit code of the response",
+			script:       "../scripts/worker.sh",
+			payload:      "fail",
+			expectStderr: "failed",
+			expectError:  &common.ScriptExitError{ExitCode: 2},
+		},
+		{
+			name:        "it should return error when the worker doesn't exist",
+			script:      "../scripts/non-exist.sh",
+			payload:     "hello world",
+			expectError: common.ErrScriptNotExist,
+		},
+		{
+			name:        "it should kill the worker when it runs longer than the timeout",
+			script:      "../scripts/worker-sleep.sh",
+			payload:     "hello world",
+			timeout:     200 * time.Millisecond,
+			expectError: common.ErrScriptTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newCoprocessPool(tt.script, 1, 200*time.Millisecond, logrus.New())
+			defer pool.close()
+			start := time.Now()
+			stdout, stderr, err := pool.call(context.Background(), []byte(tt.payload), nil, tt.timeout)
+			assert.Less(t, time.Since(start), 2*time.Second)
+			assert.Equal(t, tt.expectStdout, string(stdout))
+			assert.Equal(t, tt.expectStderr, string(stderr))
+			assert.Equal(t, tt.expectError, err)
+		})
+	}
+}
+
+func TestCoprocessPool_Restart(t *testing.T) {
+	requireJQ(t)
+	pool := newCoprocessPool("../scripts/worker.sh", 1, 200*time.Millisecond, logrus.New())
+	defer pool.close()
+
+	stdout, _, err := pool.call(context.Background(), []byte("first"), nil, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "first!", string(stdout))
+
+	// kill the worker behind the pool, it should be restarted for the next message
+	worker := <-pool.idle
+	assert.Nil(t, syscall.Kill(-worker.pid, syscall.SIGKILL))
+	<-worker.exited
+	pool.idle <- worker
+
+	stdout, _, err = pool.call(context.Background(), []byte("second"), nil, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "second!", string(stdout))
+	assert.Equal(t, uint64(1), pool.restarts)
+}
+
+func TestCoprocessPool_LongStderr(t *testing.T) {
+	requireJQ(t)
+	logger := logrus.New()
+	var output bytes.Buffer
+	logger.SetOutput(&output)
+	pool := newCoprocessPool("../scripts/worker-stderr.sh", 1, 200*time.Millisecond, logger)
+
+	// the worker would block on writing its stderr if the long lines stopped the reading
+	for _, payload := range []string{"first", "second"} {
+		stdout, _, err := pool.call(context.Background(), []byte(payload), nil, 5*time.Second)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, payload+"!", string(stdout))
+	}
+	// the stderr is read to the end once the worker exits
+	pool.close()
+	assert.Contains(t, output.String(), "worker error: done")
+}
+
+func TestReadLines(t *testing.T) {
+	var lines []string
+	input := "short\n" + strings.Repeat("x", 10) + "\n\nlast"
+	err := readLines(bufio.NewReaderSize(strings.NewReader(input), 16), 4, func(line []byte) {
+		lines = append(lines, string(line))
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"shor", "xxxx", "", "last"}, lines)
+}
+
+func TestCoprocessPool_Crash(t *testing.T) {
+	pool := newCoprocessPool("../scripts/worker-crash.sh", 1, 200*time.Millisecond, logrus.New())
+	defer pool.close()
+
+	for i := 0; i < 3; i++ {
+		_, _, err := pool.call(context.Background(), []byte("hello world"), nil, time.Second)
+		assert.ErrorIs(t, err, common.ErrScriptExecError)
+	}
+	assert.Equal(t, uint64(2), pool.restarts)
+}
+
+func TestRunner_ProcessWorker(t *testing.T) {
+	requireJQ(t)
+	source := newMemorySource(&mockMessage{payload: []byte("hello")}, &mockMessage{payload: []byte("fail")})
+	sink := NewMemorySink()
+	runner := &Runner{
+		source:      source,
+		sink:        sink,
+		logger:      logrus.New(),
+		execOptions: execOptions{execMode: ExecModeWorker},
+	}
+
+	err := runner.Run(context.Background(), "../scripts/worker.sh")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(source.Acked()))
+	assert.Equal(t, 1, len(source.Nacked()))
+	assert.Equal(t, 1, len(sink.Sent("")))
+	assert.Equal(t, "hello!", string(sink.Sent("")[0].Payload))
+}
+
+func BenchmarkExecScript(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, _, err := execScript(context.Background(), "../scripts/exec.sh", []byte("hello world"), nil, execOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCoprocess(b *testing.B) {
+	requireJQ(b)
+	pool := newCoprocessPool("../scripts/worker.sh", 1, time.Second, logrus.New())
+	defer pool.close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := pool.call(context.Background(), []byte("hello world"), nil, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"math"
+	"strconv"
+	"time"
+)
+
+// properties attached to the messages sent to the dead letter topic, along with the lineage properties
+const (
+	PropertyExitCode = "exit-code"
+	PropertyStderr   = "stderr"
+	PropertyError    = "error"
+)
+
+const defaultRetryDelay = time.Minute
+
+// stderrPreviewSize is how many bytes of the stderr are put in the dead letter message, so that it stays far below
+// the max message size of the broker
+const stderrPreviewSize = 4096
+
+// DeadLetterPolicy sends the messages which still fail after MaxRedeliveries redeliveries to the DeadLetterTopic
+type DeadLetterPolicy struct {
+	MaxRedeliveries uint32
+	DeadLetterTopic string
+	// RetryLetterTopic is optional, failed messages are republished to it with a delay instead of being nacked
+	RetryLetterTopic string
+}
+
+// applyTo enables the retry letter topic on the consumer, the dead letter topic is handled by the runner itself
+// because pulsar doesn't allow to attach the exit code and stderr to the dead letter message. Pulsar always routes
+// to a dead letter topic of its own when the retry is enabled, so its max deliveries is set out of reach, the runner
+// dead-letters the messages before they're reconsumed or nacked MaxRedeliveries times.
+func (policy DeadLetterPolicy) applyTo(options *pulsar.ConsumerOptions) {
+	if policy.RetryLetterTopic == "" {
+		return
+	}
+	options.RetryEnable = true
+	options.DLQ = &pulsar.DLQPolicy{
+		MaxDeliveries:    math.MaxUint32,
+		RetryLetterTopic: policy.RetryLetterTopic,
+	}
+}
+
+type deadLetter struct {
+	policy     DeadLetterPolicy
+	sink       Sink
+	retryDelay time.Duration
+}
+
+func newDeadLetter(sink Sink, policy DeadLetterPolicy, retryDelay time.Duration) (*deadLetter, error) {
+	if policy.DeadLetterTopic == "" {
+		return nil, errors.New("dead letter topic is not specified")
+	}
+	if policy.RetryLetterTopic != "" && policy.MaxRedeliveries < 1 {
+		return nil, errors.New("max redeliveries must be at least 1 with the retry letter topic")
+	}
+	if retryDelay <= 0 {
+		retryDelay = defaultRetryDelay
+	}
+	return &deadLetter{
+		policy:     policy,
+		sink:       sink,
+		retryDelay: retryDelay,
+	}, nil
+}
+
+// exhausted reports whether the message has been redelivered too many times, either by nack or by the retry topic
+func (deadLetter *deadLetter) exhausted(msg Message) bool {
+	return redeliveries(msg) >= deadLetter.policy.MaxRedeliveries
+}
+
+// send publishes the message to the dead letter topic with its original properties and the failure details
+func (deadLetter *deadLetter) send(msg Message, cause error, stderr []byte) error {
+	return deadLetter.sink.Send(context.Background(), deadLetter.policy.DeadLetterTopic, failureMessage(msg, cause, stderr))
+}
+
+// failureMessage copies the message with its original properties, and adds the failure details to the properties.
+// The system properties of the retry letter topic are dropped, and only the first stderrPreviewSize bytes of the
+// stderr are kept.
+func failureMessage(msg Message, cause error, stderr []byte) *OutputMessage {
+	properties := make(map[string]string, len(msg.Properties())+5)
+	for key, value := range msg.Properties() {
+		if !systemProperties[key] {
+			properties[key] = value
+		}
+	}
+	properties[PropertyExitCode] = strconv.Itoa(common.ExitCode(cause))
+	properties[PropertyStderr] = preview(stderr, stderrPreviewSize)
+	properties[PropertyError] = cause.Error()
+	properties[PropertySourceTopic] = sourceTopic(msg)
+	properties[PropertySourceMessageID] = msg.ID()
+	return &OutputMessage{
+		Payload:    msg.Payload(),
+		Key:        msg.Key(),
+		Properties: properties,
+	}
+}
+
+// retry redelivers the message which the script failed to process, until the dead letter policy gives up
+func (runner *Runner) retry(msg Message, cause error, stderr []byte) {
+	if runner.deadLetter != nil && runner.deadLetter.exhausted(msg) {
+		runner.sendToDeadLetter(msg, cause, stderr,
+			fmt.Sprintf("after %d redeliveries", runner.deadLetter.policy.MaxRedeliveries))
+		return
+	}
+
+	runner.observeRetried(msg)
+	if runner.deadLetter == nil {
+		runner.source.Nack(msg)
+		return
+	}
+
+	if runner.deadLetter.policy.RetryLetterTopic != "" {
+		runner.source.ReconsumeLater(msg, runner.deadLetter.retryDelay)
+		return
+	}
+	runner.source.Nack(msg)
+}
+
+// sendToDeadLetter sends the message to the dead letter topic and acks it, it's nacked when failed to send it
+func (runner *Runner) sendToDeadLetter(msg Message, cause error, stderr []byte, reason string) {
+	err := common.Retry(func() error {
+		return runner.deadLetter.send(msg, cause, stderr)
+	}, common.RetryConfig{Attempts: 3, Delay: 100 * time.Millisecond})
+	if err != nil {
+		runner.observeSendFailure(runner.deadLetter.policy.DeadLetterTopic)
+		runner.messageLog(msg).Errorf("failed to send message to dead letter topic: %s, nack it", err)
+		runner.source.Nack(msg)
+		return
+	}
+	runner.observeDeadLettered(msg)
+	runner.messageLog(msg).WithField("exit_code", common.ExitCode(cause)).Warnf("message is sent to dead letter topic %s %s",
+		runner.deadLetter.policy.DeadLetterTopic, reason)
+	runner.source.Ack(msg)
+}
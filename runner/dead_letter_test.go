@@ -0,0 +1,149 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"context"
+	"errors"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRunner_DeadLetter(t *testing.T) {
+	tests := []struct {
+		name              string
+		policy            DeadLetterPolicy
+		message           *mockMessage
+		deadLetterErr     error
+		expectAcks        int
+		expectNacks       int
+		expectReconsumes  int
+		expectDeadLetters int
+	}{
+		{
+			name:   "it should nack the message before reaching the max redeliveries",
+			policy: DeadLetterPolicy{MaxRedeliveries: 3, DeadLetterTopic: "dlq"},
+			message: &mockMessage{
+				payload:         []byte("hello"),
+				redeliveryCount: 2,
+			},
+			expectNacks: 1,
+		},
+		{
+			name:   "it should send the message to the dead letter topic after max redeliveries",
+			policy: DeadLetterPolicy{MaxRedeliveries: 3, DeadLetterTopic: "dlq"},
+			message: &mockMessage{
+				payload:         []byte("hello"),
+				redeliveryCount: 3,
+			},
+			expectAcks:        1,
+			expectDeadLetters: 1,
+		},
+		{
+			name:   "it should nack the message when failed to send it to the dead letter topic",
+			policy: DeadLetterPolicy{MaxRedeliveries: 3, DeadLetterTopic: "dlq"},
+			message: &mockMessage{
+				payload:         []byte("hello"),
+				redeliveryCount: 3,
+			},
+			deadLetterErr: errors.New("producer closed"),
+			expectNacks:   1,
+		},
+		{
+			name:   "it should send the message to the retry letter topic before reaching the max redeliveries",
+			policy: DeadLetterPolicy{MaxRedeliveries: 3, DeadLetterTopic: "dlq", RetryLetterTopic: "retry"},
+			message: &mockMessage{
+				payload:    []byte("hello"),
+				properties: map[string]string{pulsar.SysPropertyReconsumeTimes: "1"},
+			},
+			expectReconsumes: 1,
+		},
+		{
+			name:   "it should count the reconsume times of the retry letter topic",
+			policy: DeadLetterPolicy{MaxRedeliveries: 3, DeadLetterTopic: "dlq", RetryLetterTopic: "retry"},
+			message: &mockMessage{
+				payload:    []byte("hello"),
+				properties: map[string]string{pulsar.SysPropertyReconsumeTimes: "3"},
+			},
+			expectAcks:        1,
+			expectDeadLetters: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newMemorySource(tt.message)
+			sink := NewMemorySink()
+			sink.FailWith(tt.deadLetterErr)
+			runner := &Runner{
+				source: source,
+				sink:   sink,
+				deadLetter: &deadLetter{
+					policy: tt.policy,
+					sink:   sink,
+				},
+				logger: logrus.New(),
+			}
+
+			err := runner.Run(context.Background(), "../scripts/exit.sh")
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tt.expectAcks, len(source.Acked()))
+			assert.Equal(t, tt.expectNacks, len(source.Nacked()))
+			assert.Equal(t, tt.expectReconsumes, len(source.Reconsumed()))
+			assert.Equal(t, tt.expectDeadLetters, len(sink.Sent("dlq")))
+		})
+	}
+}
+
+func TestDeadLetter_Send(t *testing.T) {
+	sink := NewMemorySink()
+	deadLetter := &deadLetter{
+		policy: DeadLetterPolicy{MaxRedeliveries: 1, DeadLetterTopic: "dlq"},
+		sink:   sink,
+	}
+	msg := &mockMessage{
+		id:      "1:2:-1:-1",
+		payload: []byte("hello"),
+		topic:   "persistent://public/default/retry",
+		key:     "key",
+		properties: map[string]string{
+			"trace":                          "abc",
+			pulsar.SysPropertyRealTopic:      "persistent://public/default/in",
+			pulsar.SysPropertyReconsumeTimes: "3",
+			pulsar.SysPropertyDelayTime:      "60000",
+		},
+	}
+
+	err := deadLetter.send(msg, &common.ScriptExitError{ExitCode: 2}, []byte("bad input"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(sink.Sent("dlq")))
+	sent := sink.Sent("dlq")[0]
+	assert.Equal(t, []byte("hello"), sent.Payload)
+	assert.Equal(t, "key", sent.Key)
+	assert.Equal(t, "abc", sent.Properties["trace"])
+	assert.Equal(t, "2", sent.Properties[PropertyExitCode])
+	assert.Equal(t, "bad input", sent.Properties[PropertyStderr])
+	assert.Equal(t, "persistent://public/default/in", sent.Properties[PropertySourceTopic])
+	assert.Equal(t, "1:2:-1:-1", sent.Properties[PropertySourceMessageID])
+	assert.NotContains(t, sent.Properties, pulsar.SysPropertyRealTopic)
+	assert.NotContains(t, sent.Properties, pulsar.SysPropertyReconsumeTimes)
+	assert.NotContains(t, sent.Properties, pulsar.SysPropertyDelayTime)
+	// the original message should not be changed
+	assert.Equal(t, 4, len(msg.properties))
+}
+
+func TestFailureMessage_LongStderr(t *testing.T) {
+	stderr := strings.Repeat("a", stderrPreviewSize+10)
+	sent := failureMessage(&mockMessage{id: "1:1:-1:-1"}, &common.ScriptExitError{ExitCode: 1}, []byte(stderr))
+	assert.Equal(t, stderr[:stderrPreviewSize]+"...", sent.Properties[PropertyStderr])
+}
+
+func TestNewDeadLetter(t *testing.T) {
+	_, err := newDeadLetter(NewMemorySink(), DeadLetterPolicy{DeadLetterTopic: "dlq", RetryLetterTopic: "retry"}, 0)
+	assert.EqualError(t, err, "max redeliveries must be at least 1 with the retry letter topic")
+	deadLetter, err := newDeadLetter(NewMemorySink(), DeadLetterPolicy{MaxRedeliveries: 1, DeadLetterTopic: "dlq", RetryLetterTopic: "retry"}, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, defaultRetryDelay, deadLetter.retryDelay)
+}
@@ -5,140 +5,629 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/apache/pulsar-client-go/pulsar"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Config holds the parameters used to create a Runner
+type Config struct {
+	PulsarURL string
+	// Auth decides how to authenticate to pulsar and verify the brokers
+	Auth AuthConfig
+	// PrivateEnv lists the environments of the runtime which are not passed to the script, like the credentials of
+	// pulsar, so that the script can't leak them to its stderr
+	PrivateEnv  []string
+	LogTopic    string
+	InputTopics string
+	// TopicsPattern subscribes to the topics matching the regular expression like persistent://tenant/ns/orders-.*
+	// instead of the InputTopics, it can't be used with the retry letter topic
+	TopicsPattern string
+	// TopicsDiscoveryPeriod is how often the topics matching the TopicsPattern are discovered, the pulsar
+	// default(1 min) is used when it's zero
+	TopicsDiscoveryPeriod time.Duration
+	Subscription          string
+	// SubscriptionType is one of Exclusive, Failover, Shared and Key_Shared, Shared is used when it's empty
+	SubscriptionType string
+	// InitialPosition is where a new subscription starts, one of earliest and latest, latest is used when it's empty
+	InitialPosition string
+	OutputTopic     string
+	// InputMode is one of argv, stdin and file, argv is used when it's empty
+	InputMode string
+	// OutputMode is one of raw, jsonl and fd, raw is used when it's empty
+	OutputMode string
+	// ExecMode is one of fork and worker, fork is used when it's empty. The worker mode doesn't support the fd output
+	// mode, and the InputMode is ignored in it
+	ExecMode string
+	// Propagation decides what is copied from the input message to the output messages
+	Propagation PropagationPolicy
+	// Filter decides when the script drops a message, a message is filtered only when it has no output by default
+	Filter FilterPolicy
+	// ExitCodePolicy maps the exit codes to the actions like "2=drop,65=dead-letter,4=route:bad-input,70=crash,timeout=retry",
+	// failed messages are retried by default
+	ExitCodePolicy string
+	// NackRedeliveryDelay is the delay before pulsar redelivers a message which failed to be processed,
+	// the pulsar default(1 min) is used when it's zero
+	NackRedeliveryDelay time.Duration
+	// DeadLetterPolicy is optional, failed messages are redelivered forever when it's nil
+	DeadLetterPolicy *DeadLetterPolicy
+	// DrainTimeout is how long the in-flight script can keep running after Run is asked to stop,
+	// the script is killed and its message is nacked when it expires
+	DrainTimeout time.Duration
+	// ScriptTimeout kills the script when it runs longer than it for a message, no timeout when it's zero
+	ScriptTimeout time.Duration
+	// ScriptKillGrace is the time between sending SIGTERM and SIGKILL to the script when killing it
+	ScriptKillGrace time.Duration
+	// Concurrency is the number of scripts running at the same time, 1 is used when it's not positive
+	Concurrency int
+	// OrderByKey makes the messages with a same key processed sequentially when Concurrency > 1,
+	// it's always enabled for Key_Shared subscription
+	OrderByKey bool
+	// Batch runs the script once for a batch of messages when it's enabled, the payloads are passed in the stdin or
+	// file input mode, and the output records are read in the jsonl or fd output mode
+	Batch BatchPolicy
+	// Window runs the script once for a window of messages when it's enabled, the messages are passed like a batch,
+	// and Concurrency is ignored so that the windows are processed in order. It can't be used with Batch.
+	Window WindowPolicy
+	// ReceiverQueueSize limits how many messages the consumer prefetches, the pulsar default(1000) is used when
+	// it's zero
+	ReceiverQueueSize int
+	// LivenessTimeout is how long the runner can make no progress while it has pending messages before it's reported
+	// as not live, it should be longer than the ScriptTimeout and the window length. 0 disables the check.
+	LivenessTimeout time.Duration
+	// Log decides how the logs are formatted and whether they carry the payloads
+	Log LogConfig
+}
+
+// execOptions controls how the script is executed
+type execOptions struct {
+	execMode   ExecMode
+	inputMode  InputMode
+	outputMode OutputMode
+	timeout    time.Duration
+	killGrace  time.Duration
+	// privateEnv holds the environments which are not passed to the script
+	privateEnv map[string]bool
+}
+
 type Runner struct {
+	// number of the filtered messages, accessed atomically, keep it first to be 64-bit aligned
+	filtered     uint64
 	pulsarWriter *common.PulsarWriter
+	// client is nil when the runner is not created by NewRunner
 	client pulsar.Client
-	consumer pulsar.Consumer
-	producer pulsar.Producer
-	logger *logrus.Logger
-	running bool
+	source Source
+	sink   Sink
+	// outputTopic is the default topic of the outputs, it's only used to label the metrics
+	outputTopic string
+	deadLetter  *deadLetter
+	// coprocesses runs the script in the worker exec mode, it's created by Run
+	coprocesses    *coprocessPool
+	logger         *logrus.Logger
+	payloadLogging PayloadLogging
+	drainTimeout   time.Duration
+	execOptions    execOptions
+	propagation    PropagationPolicy
+	filterPolicy   FilterPolicy
+	exitCodePolicy ExitCodePolicy
+	concurrency    int
+	orderByKey     bool
+	// queueSize is how many messages a worker queues with key ordering
+	queueSize int
+	batch     BatchPolicy
+	window    WindowPolicy
+	metrics   *metrics
+	// traces holds the receive spans of the messages, it's nil when the runner is not created by NewRunnerWith
+	traces *tracingSource
+	// progress tracks the messages of the source for the liveness
+	progress        *progress
+	livenessTimeout time.Duration
+	// connection checks the connection to pulsar for the readiness, it's nil when the runner is not created by NewRunner
+	connection *connectionChecker
+	running    bool
+	// stop cancels the context of Run, it's used to crash the runner
+	stop      context.CancelFunc
+	crashOnce sync.Once
+	crashErr  error
 }
 
-func NewRunner(pulsarUrl string, logTopic string, inputTopics string, subscription string, outputTopic string) (*Runner, error) {
-	client, err := pulsar.NewClient(pulsar.ClientOptions{
-		URL: pulsarUrl,
-	})
+// NewRunner creates a runner which receives the messages from the InputTopics and sends the outputs to the
+// OutputTopic of pulsar, the config is validated before connecting to pulsar
+func NewRunner(config Config) (*Runner, error) {
+	subscriptionType, err := parseSubscriptionType(config.SubscriptionType)
 	if err != nil {
-		logrus.Errorf("Faild to connect pulsar, %s", err)
+		logrus.Errorf("Invalid subscription type, %s", err)
 		return nil, err
 	}
+	options, err := consumerOptions(config, subscriptionType)
+	if err != nil {
+		logrus.Errorf("Invalid subscription, %s", err)
+		return nil, err
+	}
+	runner, err := newRunner(config)
+	if err != nil {
+		return nil, err
+	}
+	// key shared subscription delivers messages with a same key to a same consumer, keep their order in the consumer
+	runner.orderByKey = config.OrderByKey || subscriptionType == pulsar.KeyShared
 
-	producer, err := client.CreateProducer(pulsar.ProducerOptions{
-		Topic: outputTopic,
-	})
+	client, err := newClient(config)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := newPulsarSink(client, config.OutputTopic)
 	if err != nil {
 		logrus.Errorf("Faild to create producer, %s", err)
+		client.Close()
 		return nil, err
 	}
-
-	topics := strings.Split(inputTopics, ",")
-	consumer, err := client.Subscribe(pulsar.ConsumerOptions{
-		Topics:            topics,
-		SubscriptionName: subscription,
-		Type:             pulsar.Shared, // make parallel processing available
-	})
+	consumer, err := client.Subscribe(options)
 	if err != nil {
 		logrus.Errorf("Faild to create consumer, %s", err)
+		sink.Close()
+		client.Close()
 		return nil, err
 	}
+	runner.client = client
+	runner.attach(newPulsarSource(consumer), sink)
 
-	var pulsarWriter *common.PulsarWriter
-	logger := logrus.StandardLogger()
-	if logTopic != "" {
-		pulsarWriter, err = common.NewPulsarWriter(logTopic, client)
+	if config.LogTopic != "" {
+		runner.pulsarWriter, err = common.NewPulsarWriter(config.LogTopic, client)
 		if err != nil {
 			logrus.Errorf("Faild to create log producer, %s", err)
+			runner.Close()
 			return nil, err
 		}
-		logger = logrus.New()
-		logger.SetOutput(io.MultiWriter(os.Stdout, pulsarWriter))
+		runner.logger.SetOutput(io.MultiWriter(os.Stdout, runner.pulsarWriter))
+	}
+	runner.connection = startConnectionChecker(func() error {
+		// it looks up the topic from the broker, which fails when the broker can't be reached
+		_, err := client.TopicPartitions(config.OutputTopic)
+		return err
+	}, connectionCheckInterval, runner.logger)
+	return runner, nil
+}
+
+// newClient creates the pulsar client with the authentication of the config
+func newClient(config Config) (pulsar.Client, error) {
+	options := pulsar.ClientOptions{
+		URL: config.PulsarURL,
+	}
+	if err := config.Auth.applyTo(&options, logrus.StandardLogger()); err != nil {
+		logrus.Errorf("Invalid authentication, %s", err)
+		return nil, err
+	}
+	client, err := pulsar.NewClient(options)
+	if err != nil {
+		logrus.Errorf("Faild to connect pulsar, %s", err)
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewRunnerWith creates a runner which receives the messages from the source and sends the outputs to the sink,
+// the pulsar settings of the config are ignored
+func NewRunnerWith(config Config, source Source, sink Sink) (*Runner, error) {
+	runner, err := newRunner(config)
+	if err != nil {
+		return nil, err
+	}
+	runner.attach(source, sink)
+	return runner, nil
+}
+
+// newRunner validates the config and creates the runner without its source and sink, which are set by attach
+func newRunner(config Config) (*Runner, error) {
+	inputMode, err := parseInputMode(config.InputMode)
+	if err != nil {
+		logrus.Errorf("Invalid input mode, %s", err)
+		return nil, err
+	}
+	outputMode, err := parseOutputMode(config.OutputMode)
+	if err != nil {
+		logrus.Errorf("Invalid output mode, %s", err)
+		return nil, err
+	}
+	execMode, err := parseExecMode(config.ExecMode)
+	if err != nil {
+		logrus.Errorf("Invalid exec mode, %s", err)
+		return nil, err
+	}
+	if execMode == ExecModeWorker && outputMode == OutputModeFD {
+		err = errors.New("fd output mode is not supported in worker exec mode")
+		logrus.Errorf("Invalid output mode, %s", err)
+		return nil, err
+	}
+	if config.Batch.enabled() {
+		if err = validateBatchModes(inputMode, outputMode, execMode); err != nil {
+			logrus.Errorf("Invalid batch mode, %s", err)
+			return nil, err
+		}
+	}
+	if config.Window.enabled() {
+		if err = validateWindow(config.Window, config.Batch, inputMode, execMode); err != nil {
+			logrus.Errorf("Invalid window, %s", err)
+			return nil, err
+		}
+	}
+	exitCodePolicy, err := parseExitCodePolicy(config.ExitCodePolicy)
+	if err != nil {
+		logrus.Errorf("Invalid exit code policy, %s", err)
+		return nil, err
+	}
+	if exitCodePolicy.usesDeadLetter() && config.DeadLetterPolicy == nil {
+		err = errors.New("dead letter policy is required by the dead-letter action of the exit code policy")
+		logrus.Errorf("Invalid exit code policy, %s", err)
+		return nil, err
+	}
+
+	logger, err := newLogger(config.Log)
+	if err != nil {
+		logrus.Errorf("Invalid log config, %s", err)
+		return nil, err
+	}
+	payloadLogging, err := parsePayloadLogging(config.Log.Payload)
+	if err != nil {
+		logrus.Errorf("Invalid log config, %s", err)
+		return nil, err
+	}
+
+	var deadLetter *deadLetter
+	if config.DeadLetterPolicy != nil {
+		deadLetter, err = newDeadLetter(nil, *config.DeadLetterPolicy, config.NackRedeliveryDelay)
+		if err != nil {
+			logrus.Errorf("Invalid dead letter policy, %s", err)
+			return nil, err
+		}
+	}
+
+	privateEnv := make(map[string]bool, len(config.PrivateEnv))
+	for _, name := range config.PrivateEnv {
+		privateEnv[name] = true
 	}
 
 	return &Runner{
-		pulsarWriter: pulsarWriter,
-		producer: producer,
-		consumer: consumer,
-		client: client,
-		logger: logger,
+		outputTopic:    config.OutputTopic,
+		deadLetter:     deadLetter,
+		logger:         logger,
+		payloadLogging: payloadLogging,
+		drainTimeout:   config.DrainTimeout,
+		execOptions: execOptions{
+			execMode:   execMode,
+			inputMode:  inputMode,
+			outputMode: outputMode,
+			timeout:    config.ScriptTimeout,
+			killGrace:  config.ScriptKillGrace,
+			privateEnv: privateEnv,
+		},
+		propagation:     config.Propagation,
+		filterPolicy:    config.Filter,
+		exitCodePolicy:  exitCodePolicy,
+		concurrency:     config.Concurrency,
+		orderByKey:      config.OrderByKey,
+		queueSize:       config.ReceiverQueueSize,
+		batch:           config.Batch,
+		window:          config.Window,
+		metrics:         newMetrics(),
+		livenessTimeout: config.LivenessTimeout,
 	}, nil
 }
 
-func (runner *Runner) Run(scriptFile string) error  {
-	// do not allow running in parallel using a same instance
+// attach makes the runner receive the messages from the source and send the outputs to the sink, the source is tracked
+// for the liveness and the traces
+func (runner *Runner) attach(source Source, sink Sink) {
+	runner.progress = newProgress()
+	runner.traces = &tracingSource{Source: &progressSource{Source: source, progress: runner.progress}}
+	runner.source = runner.traces
+	runner.sink = sink
+	if runner.deadLetter != nil {
+		runner.deadLetter.sink = sink
+	}
+}
+
+// Run receives messages and processes them with the script until ctx is done or the consumer is closed,
+// the in-flight scripts are given DrainTimeout to finish after ctx is done. An error is returned when the runner
+// is stopped by the crash action of the exit code policy, or when the source fails before it ends.
+func (runner *Runner) Run(ctx context.Context, scriptFile string) error {
+	// do not allow running in parallel using a same instance, use Concurrency instead
 	if runner.running {
 		return errors.New("runner is already running")
 	}
 	runner.running = true
+	ctx, runner.stop = context.WithCancel(ctx)
+	defer runner.stop()
+	execCtx, cancel := withDrainTimeout(ctx, runner.drainTimeout)
+	defer cancel()
+	if runner.execOptions.execMode == ExecModeWorker {
+		runner.coprocesses = newCoprocessPool(scriptFile, runner.concurrency, runner.execOptions.killGrace, runner.logger)
+		runner.coprocesses.restarted = runner.observeWorkerRestart
+		runner.coprocesses.privateEnv = runner.execOptions.privateEnv
+	}
+	var pool *workerPool
+	if runner.window.enabled() {
+		pool = runner.newWindowWorkerPool(execCtx, scriptFile)
+	} else if runner.batch.enabled() {
+		pool = newBatchWorkerPool(runner.concurrency, runner.orderByKey, runner.workerQueueSize(), runner.batch,
+			func(msgs []Message) {
+				runner.processBatch(execCtx, scriptFile, msgs)
+			})
+	} else {
+		pool = newWorkerPool(runner.concurrency, runner.orderByKey, runner.workerQueueSize(), func(msg Message) {
+			runner.process(execCtx, scriptFile, msg)
+		})
+	}
+	var sourceErr error
 	for {
-		msg, err := runner.consumer.Receive(context.Background())
+		msg, err := runner.source.Receive(ctx)
 		if err != nil {
-			runner.logger.Errorf("consumer is closed or context is done")
+			if ctx.Err() != nil {
+				runner.logger.Infof("stop receiving messages: %s", ctx.Err())
+			} else if err == io.EOF {
+				runner.logger.Infof("no more messages in the source")
+			} else {
+				runner.logger.Errorf("source is closed: %s", err)
+				sourceErr = fmt.Errorf("source is closed: %w", err)
+			}
 			break
 		}
-		runner.consumer.AckID(msg.ID())
+		runner.observeReceived(msg)
+		if !pool.dispatch(ctx, msg) {
+			runner.logger.Infof("stop receiving messages: %s", ctx.Err())
+			runner.source.Nack(msg)
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		// the queued messages are not started yet, leave them to be redelivered
+		for _, msg := range pool.drain() {
+			runner.source.Nack(msg)
+		}
+	}
+	pool.stop()
+	if runner.coprocesses != nil {
+		runner.coprocesses.close()
+	}
+	runner.running = false
+	if runner.crashErr != nil {
+		return runner.crashErr
+	}
+	return sourceErr
+}
 
-		stdout, stderr, err := execScript(scriptFile, string(msg.Payload()))
-		if err != nil {
-			runner.logger.Errorf("failed to process message: %s", err)
-			continue
+// workerQueueSize returns how many messages a worker queues with key ordering, it follows the receiver queue size of
+// the consumer
+func (runner *Runner) workerQueueSize() int {
+	if runner.queueSize > 0 {
+		return runner.queueSize
+	}
+	return defaultReceiverQueueSize
+}
+
+// crash stops Run with the error, only the first error is kept
+func (runner *Runner) crash(err error) {
+	runner.crashOnce.Do(func() {
+		runner.logger.Errorf("runner crashed: %s", err)
+		runner.crashErr = err
+		runner.stop()
+	})
+}
+
+// withDrainTimeout returns a context which is done the drain timeout after the parent is done
+func withDrainTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+	return ctx, cancel
+}
 
+// process runs the script with the given message and publishes the result, the message is acked only after the
+// result is sent successfully, otherwise it's nacked so that pulsar will redeliver it later
+func (runner *Runner) process(ctx context.Context, scriptFile string, msg Message) {
+	start := time.Now()
+	stdout, stderr, err := runner.exec(ctx, scriptFile, msg)
+	log := runner.messageLog(msg).WithFields(scriptFields(err, time.Since(start)))
+	if runner.filterPolicy.filtersExit(err) {
+		runner.filter(msg, "exit code")
+		return
+	}
+	if err != nil {
+		log.Errorf("failed to process message: %s", err)
 		if len(stderr) > 0 {
-			runner.logger.Errorf("error: %s", stderr)
+			log.Errorf("error: %s", stderr)
 		}
-		runner.logger.Infof("process message '%s' successfully", msg.Payload())
+		if ctx.Err() != nil {
+			// the script is killed because of shutdown, it's not the message's fault
+			runner.source.Nack(msg)
+			return
+		}
+		runner.fail(msg, err, stderr)
+		return
+	}
 
-		// retry sending message
-		err = common.Retry(func() error {
-			_, err = runner.producer.Send(context.Background(), &pulsar.ProducerMessage{
-				Payload: stdout,
-			})
-			if err != nil {
-				return err
-			}
-			return nil
-		}, common.RetryConfig{Attempts: 3, Delay: 100 * time.Millisecond})
+	if len(stderr) > 0 {
+		log.Errorf("error: %s", stderr)
+	}
+	outputs, err := parseOutput(stdout, runner.execOptions.outputMode)
+	if err != nil {
+		log.Errorf("failed to process message: %s", err)
+		runner.fail(msg, err, stderr)
+		return
+	}
+	if runner.filterPolicy.filtersOutput(stdout, outputs) {
+		runner.filter(msg, "empty output")
+		return
+	}
+	runner.publish(log, msg, outputs)
+}
+
+// publish sends the outputs of the message and acks it, the message is nacked when they can't be sent. The log
+// carries the fields of the message and its script.
+func (runner *Runner) publish(log *logrus.Entry, msg Message, outputs []output) {
+	runner.propagation.apply(msg, outputs)
+	log = log.WithField("outputs", len(outputs))
+
+	if err := runner.send([]Message{msg}, outputs); err != nil {
+		log.Errorf("failed to send message to topic: %s, nack it", err)
+		runner.source.Nack(msg)
+		return
+	}
+	log.Infof("process message successfully")
+	runner.observeSucceeded(msg)
+	runner.source.Ack(msg)
+}
+
+// exec runs the script with the message, by the worker in the worker exec mode, or by a new process otherwise
+func (runner *Runner) exec(ctx context.Context, scriptFile string, msg Message) ([]byte, []byte, error) {
+	return runner.runScript([]Message{msg}, func(traceEnv []string) ([]byte, []byte, error) {
+		env := append(messageEnv(msg), traceEnv...)
+		if runner.coprocesses != nil {
+			return runner.coprocesses.call(ctx, msg.Payload(), env, runner.execOptions.timeout)
+		}
+		return execScript(ctx, scriptFile, msg.Payload(), env, runner.execOptions)
+	})
+}
 
+// runScript runs the script for the messages by the given function with the environments of the trace context, and
+// records how long it runs
+func (runner *Runner) runScript(msgs []Message, run func(traceEnv []string) ([]byte, []byte, error)) ([]byte, []byte, error) {
+	observe := runner.observeScript()
+	ctx, span := runner.startSpan("script", msgs, trace.WithAttributes(attribute.Int("script.messages", len(msgs))))
+	start := time.Now()
+	stdout, stderr, err := run(traceEnv(ctx))
+	duration := time.Since(start)
+	observe(duration)
+	span.SetAttributes(
+		attribute.Int("process.exit.code", common.ExitCode(err)),
+		attribute.Float64("script.duration_ms", float64(duration)/float64(time.Millisecond)),
+	)
+	endSpan(span, err)
+	return stdout, stderr, err
+}
+
+// send publishes the outputs of the messages to their topics with retries, it stops at the first one which can't be
+// sent. The trace context of the publish span is added to the outputs.
+func (runner *Runner) send(msgs []Message, outputs []output) (err error) {
+	ctx, span := runner.startSpan("publish", msgs, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(outputs))))
+	defer func() {
+		endSpan(span, err)
+	}()
+	for _, out := range outputs {
+		injectTrace(ctx, out.message)
+		err := common.Retry(func() error {
+			return runner.sink.Send(context.Background(), out.topic, out.message)
+		}, common.RetryConfig{Attempts: 3, Delay: 100 * time.Millisecond})
 		if err != nil {
-			runner.logger.Errorf("failed to send message to topic: %s, skip", err)
+			runner.observeSendFailure(out.topic)
+			return err
 		}
+		runner.observeSent(out.message)
 	}
-	runner.running = false
 	return nil
 }
 
+// Flush sends the pending messages of the sink and the log producer
+func (runner *Runner) Flush() {
+	if runner == nil {
+		return
+	}
+	if err := runner.sink.Flush(); err != nil {
+		runner.logger.Errorf("failed to flush sink: %s", err)
+	}
+	runner.pulsarWriter.Flush()
+}
+
 func (runner *Runner) Close() {
 	if runner == nil {
 		return
 	}
+	if runner.connection != nil {
+		runner.connection.close()
+	}
 	runner.pulsarWriter.Close()
-	runner.consumer.Close()
-	runner.producer.Close()
-	runner.client.Close()
+	runner.source.Close()
+	runner.sink.Close()
+	if runner.client != nil {
+		runner.client.Close()
+	}
 }
 
-func execScript(file string, param string) ([]byte, []byte, error)  {
+// execScript runs the script with the payload and the extra environment variables, and returns its output and stderr,
+// the output is read from stdout, or from the output file descriptor in the fd output mode
+func execScript(ctx context.Context, file string, payload []byte, env []string, options execOptions) ([]byte, []byte, error) {
 	if _, err := exec.LookPath(file); err != nil {
 		return nil, nil, common.ErrScriptNotExist
 	}
+	scriptCtx := ctx
+	if options.timeout > 0 {
+		var cancel context.CancelFunc
+		scriptCtx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
+	}
+	cmd, cleanup, err := newCommand(file, payload, options.inputMode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", common.ErrScriptExecError, err)
+	}
+	defer cleanup()
+	cmd.Env = append(scriptEnviron(options.privateEnv), env...)
 	var outb, errb bytes.Buffer
-	cmd := exec.Command(file, param)
 	cmd.Stdout = &outb
 	cmd.Stderr = &errb
-	err := cmd.Run()
+	var readOutputFD func() []byte
+	if options.outputMode == OutputModeFD {
+		readOutputFD, err = attachOutputFD(cmd)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", common.ErrScriptExecError, err)
+		}
+		// the output is read from the file descriptor, log the stdout along with the stderr
+		cmd.Stdout = &errb
+	}
+	err = runProcessGroup(scriptCtx, cmd, options.killGrace)
+	if readOutputFD != nil {
+		outb.Write(readOutputFD())
+	}
 	outBytes := bytes.TrimRight(outb.Bytes(), "\n")
 	errBytes := bytes.TrimRight(errb.Bytes(), "\n")
 	if err != nil {
-		return nil, errBytes, common.ErrScriptExecError
+		if ctx.Err() == nil && scriptCtx.Err() == context.DeadlineExceeded {
+			return outBytes, errBytes, common.ErrScriptTimeout
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return outBytes, errBytes, &common.ScriptExitError{ExitCode: exitErr.ExitCode()}
+		}
+		return outBytes, errBytes, common.ErrScriptExecError
 	}
 	return outBytes, errBytes, nil
 }
+
+// scriptEnviron returns the environments of the runtime inherited by the script, the private ones are left out
+func scriptEnviron(private map[string]bool) []string {
+	var environ []string
+	for _, env := range os.Environ() {
+		if name, _, _ := strings.Cut(env, "="); !private[name] {
+			environ = append(environ, env)
+		}
+	}
+	return environ
+}
@@ -16,11 +16,12 @@ import (
 func TestNewRunner(t *testing.T) {
 	pulsarUrl := common.GetEnv("PULSAR_URL", "pulsar://localhost:6650")
 	type args struct {
-		pulsarUrl    string
-		logTopic     string
-		inputTopics  string
-		subscription string
-		outputTopic  string
+		pulsarUrl        string
+		logTopic         string
+		inputTopics      string
+		subscription     string
+		subscriptionType string
+		outputTopic      string
 	}
 	tests := []struct {
 		name    string
@@ -93,10 +94,41 @@ func TestNewRunner(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "it should create the runner successfully with key shared subscription",
+			args: args{
+				pulsarUrl:        pulsarUrl,
+				logTopic:         "",
+				inputTopics:      "system-test-new-input7",
+				subscription:     "system-test-new-sub7",
+				subscriptionType: "Key_Shared",
+				outputTopic:      "system-test-new-output7",
+			},
+			wantErr: false,
+		},
+		{
+			name: "it should failed to create runner when subscription type is unknown",
+			args: args{
+				pulsarUrl:        pulsarUrl,
+				logTopic:         "",
+				inputTopics:      "system-test-new-input8",
+				subscription:     "system-test-new-sub8",
+				subscriptionType: "broadcast",
+				outputTopic:      "system-test-new-output8",
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewRunner(tt.args.pulsarUrl, tt.args.logTopic, tt.args.inputTopics, tt.args.subscription, tt.args.outputTopic)
+			got, err := NewRunner(Config{
+				PulsarURL:        tt.args.pulsarUrl,
+				LogTopic:         tt.args.logTopic,
+				InputTopics:      tt.args.inputTopics,
+				Subscription:     tt.args.subscription,
+				SubscriptionType: tt.args.subscriptionType,
+				OutputTopic:      tt.args.outputTopic,
+			})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewRunner() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -160,10 +192,16 @@ func TestRunner_Run(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			scriptRunner, err := NewRunner(tt.args.pulsarUrl, tt.args.logTopic, tt.args.inputTopics, tt.args.subscription, tt.args.outputTopic)
+			scriptRunner, err := NewRunner(Config{
+				PulsarURL:    tt.args.pulsarUrl,
+				LogTopic:     tt.args.logTopic,
+				InputTopics:  tt.args.inputTopics,
+				Subscription: tt.args.subscription,
+				OutputTopic:  tt.args.outputTopic,
+			})
 			assert.Equal(t, err, nil)
 			go func() {
-				scriptRunner.Run(tt.script)
+				scriptRunner.Run(context.Background(), tt.script)
 			}()
 
 			time.Sleep(1 * time.Second) // wait for scriptRunner.consumer to start retrieve message
@@ -272,10 +310,16 @@ func TestRunner_RunInParallel(t *testing.T) {
 			for i := 0; i < instances; i++ {
 				// send processed messages to different output topic, this is just for test purpose
 				// normally we will keep all params same when user want to process messages parallel
-				scriptRunner, err := NewRunner(tt.args.pulsarUrl, tt.args.logTopic, tt.args.inputTopic, tt.args.subscription, fmt.Sprintf("%s-%d", tt.args.outputTopic, i))
+				scriptRunner, err := NewRunner(Config{
+					PulsarURL:    tt.args.pulsarUrl,
+					LogTopic:     tt.args.logTopic,
+					InputTopics:  tt.args.inputTopic,
+					Subscription: tt.args.subscription,
+					OutputTopic:  fmt.Sprintf("%s-%d", tt.args.outputTopic, i),
+				})
 				assert.Equal(t, err, nil)
 				go func() {
-					_ = scriptRunner.Run(tt.script)
+					_ = scriptRunner.Run(context.Background(), tt.script)
 				}()
 				runners = append(runners, scriptRunner)
 			}
@@ -356,18 +400,24 @@ func TestRunner_Close(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			runner, err := NewRunner(tt.args.pulsarUrl, tt.args.logTopic, tt.args.inputTopic, tt.args.subscription, tt.args.outputTopic)
+			runner, err := NewRunner(Config{
+				PulsarURL:    tt.args.pulsarUrl,
+				LogTopic:     tt.args.logTopic,
+				InputTopics:  tt.args.inputTopic,
+				Subscription: tt.args.subscription,
+				OutputTopic:  tt.args.outputTopic,
+			})
 			assert.Equal(t, err, nil)
 
 			runner.Close()
 			// failed to produce message
-			_, err = runner.producer.Send(ctx, &pulsar.ProducerMessage{
+			err = runner.sink.Send(ctx, "", &OutputMessage{
 				Payload: []byte("hello"),
 			})
 			assert.Equal(t, true, err != nil)
 
 			// failed to retrieve message
-			_, err = runner.consumer.Receive(ctx)
+			_, err = runner.source.Receive(ctx)
 			assert.Equal(t, true, err != nil)
 
 			// failed to produce message to log topic
@@ -2,8 +2,12 @@ package runner
 
 import (
 	"bash-runtime/common"
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestExec(t *testing.T) {
@@ -11,6 +15,7 @@ func TestExec(t *testing.T) {
 		name         string
 		script       string
 		param        string
+		options      execOptions
 		expectStdout string
 		expectStderr string
 		expectError  error
@@ -39,6 +44,14 @@ func TestExec(t *testing.T) {
 			expectStderr: "",
 			expectError:  common.ErrScriptNotExist,
 		},
+		{
+			name:         "it should return the exit code when the script failed",
+			script:       "../scripts/exit.sh",
+			param:        "hello world",
+			expectStdout: "",
+			expectStderr: "hello world",
+			expectError:  &common.ScriptExitError{ExitCode: 1},
+		},
 		{
 			name:         "it should get the output of stderr",
 			script:       "../scripts/stderr.sh",
@@ -47,14 +60,227 @@ func TestExec(t *testing.T) {
 			expectStderr: "../scripts/stderr.sh: line 3: data: command not found",
 			expectError:  nil,
 		},
+		{
+			name:         "it should finish the script before the timeout",
+			script:       "../scripts/sleep.sh",
+			param:        "0.1",
+			options:      execOptions{timeout: 5 * time.Second},
+			expectStdout: "0.1!",
+			expectStderr: "",
+			expectError:  nil,
+		},
+		{
+			name:         "it should kill the script when it runs longer than the timeout",
+			script:       "../scripts/sleep.sh",
+			param:        "5",
+			options:      execOptions{timeout: 200 * time.Millisecond},
+			expectStdout: "",
+			expectStderr: "",
+			expectError:  common.ErrScriptTimeout,
+		},
+		{
+			name:         "it should kill the process group when the script ignores SIGTERM",
+			script:       "../scripts/ignore-term.sh",
+			param:        "5",
+			options:      execOptions{timeout: 200 * time.Millisecond, killGrace: 200 * time.Millisecond},
+			expectStdout: "",
+			expectStderr: "",
+			expectError:  common.ErrScriptTimeout,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			stdout, stderr, err := execScript(tt.script, tt.param)
+			start := time.Now()
+			stdout, stderr, err := execScript(context.Background(), tt.script, []byte(tt.param), nil, tt.options)
+			assert.Less(t, time.Since(start), 2*time.Second)
 			assert.Equal(t, tt.expectStdout, string(stdout))
 			assert.Equal(t, tt.expectStderr, string(stderr))
 			assert.Equal(t, tt.expectError, err)
 		})
 	}
 }
+
+func TestRunner_Process(t *testing.T) {
+	tests := []struct {
+		name         string
+		script       string
+		sendErr      error
+		expectAcks   int
+		expectNacks  int
+		expectSends  int
+		expectOutput []string
+	}{
+		{
+			name:         "it should ack the message after the result is sent",
+			script:       "../scripts/exec.sh",
+			expectAcks:   1,
+			expectNacks:  0,
+			expectSends:  1,
+			expectOutput: []string{"hello world!"},
+		},
+		{
+			name:         "it should nack the message when the script doesn't exist",
+			script:       "../scripts/non-exist.sh",
+			expectAcks:   0,
+			expectNacks:  1,
+			expectSends:  0,
+			expectOutput: nil,
+		},
+		{
+			name:         "it should nack the message when the script failed",
+			script:       "../scripts/exit.sh",
+			expectAcks:   0,
+			expectNacks:  1,
+			expectSends:  0,
+			expectOutput: nil,
+		},
+		{
+			name:         "it should nack the message when failed to send the result",
+			script:       "../scripts/exec.sh",
+			sendErr:      errors.New("producer closed"),
+			expectAcks:   0,
+			expectNacks:  1,
+			expectSends:  3,
+			expectOutput: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &mockMessage{payload: []byte("hello world")}
+			source := newMemorySource(msg)
+			sink := NewMemorySink()
+			sink.FailWith(tt.sendErr)
+			runner := &Runner{
+				source: source,
+				sink:   sink,
+				logger: logrus.New(),
+			}
+
+			err := runner.Run(context.Background(), tt.script)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tt.expectAcks, len(source.Acked()))
+			assert.Equal(t, tt.expectNacks, len(source.Nacked()))
+			assert.Equal(t, tt.expectSends, sink.Attempts())
+			var output []string
+			for _, sent := range sink.Sent("") {
+				output = append(output, string(sent.Payload))
+			}
+			assert.Equal(t, tt.expectOutput, output)
+		})
+	}
+}
+
+func TestRunner_RunShutdown(t *testing.T) {
+	tests := []struct {
+		name         string
+		sleep        string
+		drainTimeout time.Duration
+		expectAcks   int
+		expectNacks  int
+	}{
+		{
+			name:         "it should finish the in-flight script within the drain timeout",
+			sleep:        "0.5",
+			drainTimeout: 5 * time.Second,
+			expectAcks:   1,
+			expectNacks:  0,
+		},
+		{
+			name:         "it should kill the in-flight script and nack the message when the drain timeout expires",
+			sleep:        "5",
+			drainTimeout: 200 * time.Millisecond,
+			expectAcks:   0,
+			expectNacks:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the source is not ended, so Run only returns when ctx is done
+			source := NewMemorySource(1)
+			source.Add(&mockMessage{payload: []byte(tt.sleep)})
+			sink := NewMemorySink()
+			runner := &Runner{
+				source:       source,
+				sink:         sink,
+				logger:       logrus.New(),
+				drainTimeout: tt.drainTimeout,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			start := time.Now()
+			err := runner.Run(ctx, "../scripts/sleep.sh")
+			assert.Equal(t, nil, err)
+			assert.Less(t, time.Since(start), 2*time.Second)
+			assert.Equal(t, tt.expectAcks, len(source.Acked()))
+			assert.Equal(t, tt.expectNacks, len(source.Nacked()))
+		})
+	}
+}
+
+func TestNewRunner_InvalidConfig(t *testing.T) {
+	// nothing listens on the port, the config should be rejected before connecting to it
+	start := time.Now()
+	_, err := NewRunner(Config{
+		PulsarURL:    "pulsar://127.0.0.1:1",
+		InputTopics:  "in",
+		Subscription: "sub",
+		OutputTopic:  "out",
+		InputMode:    "xml",
+	})
+	assert.EqualError(t, err, "unknown input mode 'xml', should be one of argv, stdin and file")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRunner_PrivateEnv(t *testing.T) {
+	t.Setenv("AUTH_TOKEN", "secret")
+	t.Setenv("TLS_KEY_FILE", "/etc/pulsar/client-key.pem")
+	tests := []struct {
+		name         string
+		execMode     string
+		script       string
+		expectOutput string
+	}{
+		{
+			name:         "it should not pass the private environments to the script",
+			script:       "../scripts/private-env.sh",
+			expectOutput: "in  ",
+		},
+		{
+			name:         "it should not pass the private environments to the worker",
+			execMode:     "worker",
+			script:       "../scripts/worker-env.sh",
+			expectOutput: " ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.execMode == "worker" {
+				requireJQ(t)
+			}
+			source := newMemorySource(&mockMessage{id: "1:1:-1:-1", topic: "in", payload: []byte("a")})
+			sink := NewMemorySink()
+			runner, err := NewRunnerWith(Config{
+				ExecMode:   tt.execMode,
+				PrivateEnv: []string{"AUTH_TOKEN", "TLS_KEY_FILE"},
+			}, source, sink)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, nil, runner.Run(context.Background(), tt.script))
+
+			sent := sink.Sent("")
+			assert.Equal(t, 1, len(sent))
+			assert.Equal(t, tt.expectOutput, string(sent[0].Payload))
+		})
+	}
+}
+
+func TestScriptEnviron(t *testing.T) {
+	t.Setenv("AUTH_TOKEN", "secret")
+	t.Setenv("PULSAR_URL", "pulsar://localhost:6650")
+	environ := scriptEnviron(map[string]bool{"AUTH_TOKEN": true})
+	assert.Contains(t, environ, "PULSAR_URL=pulsar://localhost:6650")
+	assert.NotContains(t, environ, "AUTH_TOKEN=secret")
+}
@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExitAction is what the runner does with a message when the script exits with a non-zero code
+type ExitAction struct {
+	Kind ExitActionKind
+	// Topic is the destination of ExitActionRoute
+	Topic string
+}
+
+type ExitActionKind string
+
+const (
+	// ExitActionRetry redelivers the message until the dead letter policy gives up, it's the default action
+	ExitActionRetry ExitActionKind = "retry"
+	// ExitActionDrop acks the message without sending anything, like a filtered message
+	ExitActionDrop ExitActionKind = "drop"
+	// ExitActionDeadLetter sends the message to the dead letter topic without retrying
+	ExitActionDeadLetter ExitActionKind = "dead-letter"
+	// ExitActionRoute sends the message to an alternate topic with the failure properties, like a dead letter
+	ExitActionRoute ExitActionKind = "route"
+	// ExitActionCrash nacks the message and stops the runner with an error
+	ExitActionCrash ExitActionKind = "crash"
+)
+
+// ExitCodePolicy maps the exit codes of the script to the actions, the default action applies to the exit codes
+// not in the map, and to the failures without an exit code. The timeout action applies to the scripts killed by
+// the ScriptTimeout, the default action is used for them when it's not set.
+type ExitCodePolicy struct {
+	Actions map[int]ExitAction
+	Default ExitAction
+	Timeout ExitAction
+}
+
+// parseExitCodePolicy parses the policy like "2=drop,65=dead-letter,4=route:bad-input,70=crash,timeout=retry,default=retry"
+func parseExitCodePolicy(value string) (ExitCodePolicy, error) {
+	policy := ExitCodePolicy{
+		Actions: map[int]ExitAction{},
+		Default: ExitAction{Kind: ExitActionRetry},
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return policy, fmt.Errorf("invalid exit code policy '%s', should be like <exit code>=<action>", item)
+		}
+		action, err := parseExitAction(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return policy, err
+		}
+		code := strings.TrimSpace(parts[0])
+		if code == "default" {
+			policy.Default = action
+			continue
+		}
+		if code == "timeout" {
+			policy.Timeout = action
+			continue
+		}
+		exitCode, err := strconv.Atoi(code)
+		if err != nil || exitCode <= 0 || exitCode > 255 {
+			return policy, fmt.Errorf("invalid exit code '%s', should be between 1 and 255, timeout or default", code)
+		}
+		policy.Actions[exitCode] = action
+	}
+	return policy, nil
+}
+
+func parseExitAction(value string) (ExitAction, error) {
+	parts := strings.SplitN(value, ":", 2)
+	switch kind := ExitActionKind(strings.ToLower(parts[0])); kind {
+	case ExitActionRetry, ExitActionDrop, ExitActionDeadLetter, ExitActionCrash:
+		if len(parts) == 2 {
+			return ExitAction{}, fmt.Errorf("exit action '%s' doesn't take a topic", kind)
+		}
+		return ExitAction{Kind: kind}, nil
+	case ExitActionRoute:
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return ExitAction{}, fmt.Errorf("exit action 'route' should be like route:<topic>")
+		}
+		return ExitAction{Kind: kind, Topic: strings.TrimSpace(parts[1])}, nil
+	default:
+		return ExitAction{}, fmt.Errorf("unknown exit action '%s', "+
+			"should be one of retry, drop, dead-letter, route:<topic> and crash", value)
+	}
+}
+
+// action returns the action for the error returned by the script
+func (policy ExitCodePolicy) action(err error) ExitAction {
+	if policy.Timeout.Kind != "" && errors.Is(err, common.ErrScriptTimeout) {
+		return policy.Timeout
+	}
+	if action, ok := policy.Actions[common.ExitCode(err)]; ok {
+		return action
+	}
+	if policy.Default.Kind == "" {
+		return ExitAction{Kind: ExitActionRetry}
+	}
+	return policy.Default
+}
+
+// usesDeadLetter reports whether any action sends messages to the dead letter topic directly
+func (policy ExitCodePolicy) usesDeadLetter() bool {
+	if policy.Default.Kind == ExitActionDeadLetter || policy.Timeout.Kind == ExitActionDeadLetter {
+		return true
+	}
+	for _, action := range policy.Actions {
+		if action.Kind == ExitActionDeadLetter {
+			return true
+		}
+	}
+	return false
+}
+
+// fail handles a message which the script failed to process by the action of the exit code
+func (runner *Runner) fail(msg Message, cause error, stderr []byte) {
+	runner.observeFailed(msg)
+	exitCode := common.ExitCode(cause)
+	action := runner.exitCodePolicy.action(cause)
+	switch action.Kind {
+	case ExitActionDrop:
+		runner.filter(msg, fmt.Sprintf("exit code %d", exitCode))
+	case ExitActionDeadLetter:
+		if runner.deadLetter == nil {
+			runner.retry(msg, cause, stderr)
+			return
+		}
+		runner.sendToDeadLetter(msg, cause, stderr, "by the exit code policy")
+	case ExitActionRoute:
+		runner.route(msg, action.Topic, cause, stderr)
+	case ExitActionCrash:
+		runner.source.Nack(msg)
+		runner.crash(fmt.Errorf("script exits with code %d: %w", exitCode, cause))
+	default:
+		runner.retry(msg, cause, stderr)
+	}
+}
+
+// route sends the message to the topic with the failure details and acks it, it's nacked when failed to send it
+func (runner *Runner) route(msg Message, topic string, cause error, stderr []byte) {
+	err := common.Retry(func() error {
+		return runner.sink.Send(context.Background(), topic, failureMessage(msg, cause, stderr))
+	}, common.RetryConfig{Attempts: 3, Delay: 100 * time.Millisecond})
+	if err != nil {
+		runner.observeSendFailure(topic)
+		runner.messageLog(msg).Errorf("failed to route message to topic %s: %s, nack it", topic, err)
+		runner.source.Nack(msg)
+		return
+	}
+	runner.messageLog(msg).WithField("exit_code", common.ExitCode(cause)).Warnf("message is routed to topic %s", topic)
+	runner.source.Ack(msg)
+}
@@ -0,0 +1,214 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseExitCodePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    ExitCodePolicy
+		wantErr bool
+	}{
+		{
+			name:  "it should retry all the failures by default",
+			value: "",
+			want: ExitCodePolicy{
+				Actions: map[int]ExitAction{},
+				Default: ExitAction{Kind: ExitActionRetry},
+			},
+		},
+		{
+			name:  "it should parse the actions of the exit codes",
+			value: "2=drop, 65=dead-letter,4=route:bad-input,70=crash,default=dead-letter",
+			want: ExitCodePolicy{
+				Actions: map[int]ExitAction{
+					2:  {Kind: ExitActionDrop},
+					65: {Kind: ExitActionDeadLetter},
+					4:  {Kind: ExitActionRoute, Topic: "bad-input"},
+					70: {Kind: ExitActionCrash},
+				},
+				Default: ExitAction{Kind: ExitActionDeadLetter},
+			},
+		},
+		{
+			name:  "it should parse the action of the timeouts",
+			value: "timeout=drop,default=dead-letter",
+			want: ExitCodePolicy{
+				Actions: map[int]ExitAction{},
+				Default: ExitAction{Kind: ExitActionDeadLetter},
+				Timeout: ExitAction{Kind: ExitActionDrop},
+			},
+		},
+		{
+			name:    "it should fail when the exit code is 0",
+			value:   "0=drop",
+			wantErr: true,
+		},
+		{
+			name:    "it should fail when the exit code is not a number",
+			value:   "two=drop",
+			wantErr: true,
+		},
+		{
+			name:    "it should fail when the action is unknown",
+			value:   "2=ignore",
+			wantErr: true,
+		},
+		{
+			name:    "it should fail when the route action has no topic",
+			value:   "2=route",
+			wantErr: true,
+		},
+		{
+			name:    "it should fail when the item has no action",
+			value:   "2",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExitCodePolicy(tt.value)
+			assert.Equal(t, tt.wantErr, err != nil)
+			if !tt.wantErr {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRunner_ExitCodePolicy(t *testing.T) {
+	policy, err := parseExitCodePolicy("2=drop,65=dead-letter,4=route:bad-input,70=crash")
+	assert.Equal(t, nil, err)
+	tests := []struct {
+		name              string
+		exitCode          string
+		expectAcks        int
+		expectNacks       int
+		expectFiltered    uint64
+		expectDeadLetters int
+		expectRouted      int
+		expectError       bool
+	}{
+		{
+			name:           "it should drop the message",
+			exitCode:       "2",
+			expectAcks:     1,
+			expectFiltered: 1,
+		},
+		{
+			name:              "it should send the message to the dead letter topic without retrying",
+			exitCode:          "65",
+			expectAcks:        1,
+			expectDeadLetters: 1,
+		},
+		{
+			name:         "it should route the message to the alternate topic",
+			exitCode:     "4",
+			expectAcks:   1,
+			expectRouted: 1,
+		},
+		{
+			name:        "it should nack the message and stop the runner",
+			exitCode:    "70",
+			expectNacks: 1,
+			expectError: true,
+		},
+		{
+			name:        "it should retry the message when the exit code is not in the policy",
+			exitCode:    "1",
+			expectNacks: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newMemorySource(&mockMessage{payload: []byte(tt.exitCode)})
+			sink := NewMemorySink()
+			runner := &Runner{
+				source: source,
+				sink:   sink,
+				deadLetter: &deadLetter{
+					policy: DeadLetterPolicy{MaxRedeliveries: 3, DeadLetterTopic: "dlq"},
+					sink:   sink,
+				},
+				logger:         logrus.New(),
+				exitCodePolicy: policy,
+			}
+
+			err := runner.Run(context.Background(), "../scripts/exit-code.sh")
+			assert.Equal(t, tt.expectError, err != nil)
+			if tt.expectError {
+				assert.Equal(t, 70, common.ExitCode(err))
+				assert.Equal(t, true, errors.Is(err, common.ErrScriptExecError))
+			}
+			assert.Equal(t, tt.expectAcks, len(source.Acked()))
+			assert.Equal(t, tt.expectNacks, len(source.Nacked()))
+			assert.Equal(t, tt.expectFiltered, runner.filtered)
+			assert.Equal(t, tt.expectDeadLetters, len(sink.Sent("dlq")))
+			assert.Equal(t, tt.expectRouted, len(sink.Sent("bad-input")))
+			for _, sent := range append(sink.Sent("dlq"), sink.Sent("bad-input")...) {
+				assert.Equal(t, tt.exitCode, sent.Properties[PropertyExitCode])
+				assert.Equal(t, "exit with "+tt.exitCode, sent.Properties[PropertyStderr])
+			}
+		})
+	}
+}
+
+func TestRunner_TimeoutPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		policy         string
+		expectAcks     int
+		expectNacks    int
+		expectFiltered uint64
+	}{
+		{
+			name:           "it should apply the timeout action to the killed script",
+			policy:         "timeout=drop,default=crash",
+			expectAcks:     1,
+			expectFiltered: 1,
+		},
+		{
+			name:        "it should apply the default action to the timeout without a timeout action",
+			policy:      "1=drop",
+			expectNacks: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newMemorySource(&mockMessage{payload: []byte("1")})
+			runner, err := NewRunnerWith(Config{ExitCodePolicy: tt.policy, ScriptTimeout: 100 * time.Millisecond},
+				source, NewMemorySink())
+			assert.Equal(t, nil, err)
+			assert.Equal(t, nil, runner.Run(context.Background(), "../scripts/sleep.sh"))
+			assert.Equal(t, tt.expectAcks, len(source.Acked()))
+			assert.Equal(t, tt.expectNacks, len(source.Nacked()))
+			assert.Equal(t, tt.expectFiltered, runner.filtered)
+		})
+	}
+}
+
+func TestRunner_Crash(t *testing.T) {
+	// the source is not ended, so Run only returns when the runner crashes
+	source := NewMemorySource(1)
+	source.Add(&mockMessage{payload: []byte("70")})
+	runner := &Runner{
+		source: source,
+		sink:   NewMemorySink(),
+		logger: logrus.New(),
+		exitCodePolicy: ExitCodePolicy{
+			Actions: map[int]ExitAction{70: {Kind: ExitActionCrash}},
+		},
+	}
+
+	err := runner.Run(context.Background(), "../scripts/exit-code.sh")
+	assert.Equal(t, 70, common.ExitCode(err))
+	assert.Equal(t, 1, len(source.Nacked()))
+}
@@ -0,0 +1,194 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// fileRecord is a message in the files read by FileSource and written by FileSink, one JSON object per line.
+// It has the same fields as the output records of the script, so the output of a script can be replayed to another.
+type fileRecord struct {
+	ID         string            `json:"id,omitempty"`
+	Topic      string            `json:"topic,omitempty"`
+	Key        string            `json:"key,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	EventTime  string            `json:"event_time,omitempty"`
+	Payload    *string           `json:"payload,omitempty"`
+	PayloadB64 *string           `json:"payload_b64,omitempty"`
+}
+
+// FileSource delivers the messages read from a file or stdin, the messages are never redelivered
+type FileSource struct {
+	ackRecorder
+	// readLock serializes the reads from the reader
+	readLock sync.Mutex
+	reader   *bufio.Reader
+	closer   io.Closer
+	// topic is the topic of the messages which don't have one
+	topic string
+	line  int
+}
+
+// NewFileSource reads the messages from the reader, one JSON object per line
+func NewFileSource(reader io.Reader, topic string) *FileSource {
+	source := &FileSource{
+		reader: bufio.NewReader(reader),
+		topic:  topic,
+	}
+	if closer, ok := reader.(io.Closer); ok && reader != os.Stdin {
+		source.closer = closer
+	}
+	return source
+}
+
+// OpenFileSource reads the messages from the file, or from stdin when the path is "-"
+func OpenFileSource(path string, topic string) (*FileSource, error) {
+	if path == "-" {
+		return NewFileSource(os.Stdin, topic), nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileSource(file, topic), nil
+}
+
+// Receive returns the message of the next non-blank line, it returns io.EOF at the end of the file
+func (source *FileSource) Receive(ctx context.Context) (Message, error) {
+	source.readLock.Lock()
+	defer source.readLock.Unlock()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line, err := source.reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			source.line++
+			msg, parseErr := source.parse(line)
+			if parseErr != nil {
+				return nil, fmt.Errorf("invalid message at line %d: %s", source.line, parseErr)
+			}
+			return msg, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (source *FileSource) parse(line []byte) (Message, error) {
+	var record fileRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, err
+	}
+	msg := Record{
+		ID:         record.ID,
+		Topic:      record.Topic,
+		Key:        record.Key,
+		Properties: record.Properties,
+	}
+	if msg.ID == "" {
+		msg.ID = strconv.Itoa(source.line)
+	}
+	if msg.Topic == "" {
+		msg.Topic = source.topic
+	}
+	switch {
+	case record.Payload != nil && record.PayloadB64 != nil:
+		return nil, fmt.Errorf("only one of payload and payload_b64 can be set")
+	case record.PayloadB64 != nil:
+		payload, err := base64.StdEncoding.DecodeString(*record.PayloadB64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload_b64, %s", err)
+		}
+		msg.Payload = payload
+	case record.Payload != nil:
+		msg.Payload = []byte(*record.Payload)
+	}
+	if record.EventTime != "" {
+		eventTime, err := time.Parse(time.RFC3339Nano, record.EventTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event_time, %s", err)
+		}
+		msg.EventTime = eventTime
+	}
+	return NewMessage(msg), nil
+}
+
+func (source *FileSource) Close() {
+	if source.closer != nil {
+		_ = source.closer.Close()
+	}
+}
+
+// FileSink writes the messages to a file or stdout, one JSON object per line
+type FileSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+// NewFileSink writes the messages to the writer
+func NewFileSink(writer io.Writer) *FileSink {
+	sink := &FileSink{writer: writer}
+	if closer, ok := writer.(io.Closer); ok && writer != os.Stdout && writer != os.Stderr {
+		sink.closer = closer
+	}
+	return sink
+}
+
+// CreateFileSink writes the messages to the file, or to stdout when the path is "-"
+func CreateFileSink(path string) (*FileSink, error) {
+	if path == "-" {
+		return NewFileSink(os.Stdout), nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileSink(file), nil
+}
+
+func (sink *FileSink) Send(_ context.Context, topic string, msg *OutputMessage) error {
+	record := fileRecord{
+		Topic:      topic,
+		Key:        msg.Key,
+		Properties: msg.Properties,
+		EventTime:  formatTime(msg.EventTime),
+	}
+	if utf8.Valid(msg.Payload) {
+		payload := string(msg.Payload)
+		record.Payload = &payload
+	} else {
+		encoded := base64.StdEncoding.EncodeToString(msg.Payload)
+		record.PayloadB64 = &encoded
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	_, err = sink.writer.Write(append(line, '\n'))
+	return err
+}
+
+func (sink *FileSink) Flush() error {
+	return nil
+}
+
+func (sink *FileSink) Close() {
+	if sink.closer != nil {
+		_ = sink.closer.Close()
+	}
+}
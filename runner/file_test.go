@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSource_Receive(t *testing.T) {
+	input := `{"payload":"hello","key":"k1","properties":{"a":"b"}}
+
+{"id":"m2","topic":"other","payload_b64":"/w==","event_time":"2022-04-01T08:00:00Z"}
+`
+	source := NewFileSource(strings.NewReader(input), "in")
+
+	msg, err := source.Receive(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "1", msg.ID())
+	assert.Equal(t, "in", msg.Topic())
+	assert.Equal(t, "k1", msg.Key())
+	assert.Equal(t, map[string]string{"a": "b"}, msg.Properties())
+	assert.Equal(t, "hello", string(msg.Payload()))
+
+	msg, err = source.Receive(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "m2", msg.ID())
+	assert.Equal(t, "other", msg.Topic())
+	assert.Equal(t, []byte{0xff}, msg.Payload())
+	assert.Equal(t, time.Date(2022, 4, 1, 8, 0, 0, 0, time.UTC), msg.EventTime())
+
+	_, err = source.Receive(context.Background())
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFileSource_ReceiveInvalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expectError string
+	}{
+		{
+			name:        "it should return error for the line which isn't JSON",
+			input:       `{"payload":"a"}` + "\nhello\n",
+			expectError: "invalid message at line 2",
+		},
+		{
+			name:        "it should return error when both payload and payload_b64 are set",
+			input:       `{"payload":"a","payload_b64":"YQ=="}`,
+			expectError: "invalid message at line 1: only one of payload and payload_b64 can be set",
+		},
+		{
+			name:        "it should return error for invalid event time",
+			input:       `{"payload":"a","event_time":"yesterday"}`,
+			expectError: "invalid message at line 1: invalid event_time",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := NewFileSource(strings.NewReader(tt.input), "in")
+			var err error
+			for err == nil {
+				_, err = source.Receive(context.Background())
+			}
+			assert.Contains(t, err.Error(), tt.expectError)
+		})
+	}
+}
+
+func TestFileSink_Send(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewFileSink(&buf)
+
+	assert.Nil(t, sink.Send(context.Background(), "", &OutputMessage{Payload: []byte("hello"), Key: "k1"}))
+	assert.Nil(t, sink.Send(context.Background(), "audit", &OutputMessage{
+		Payload:    []byte{0xff},
+		Properties: map[string]string{"a": "b"},
+		EventTime:  time.Date(2022, 4, 1, 8, 0, 0, 0, time.UTC),
+	}))
+	assert.Equal(t, `{"key":"k1","payload":"hello"}`+"\n"+
+		`{"topic":"audit","properties":{"a":"b"},"event_time":"2022-04-01T08:00:00Z","payload_b64":"/w=="}`+"\n",
+		buf.String())
+
+	// the output of the sink can be read back by the source
+	source := NewFileSource(&buf, "in")
+	msg, err := source.Receive(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(msg.Payload()))
+	msg, err = source.Receive(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "audit", msg.Topic())
+	assert.Equal(t, []byte{0xff}, msg.Payload())
+}
+
+func TestRunner_RunFile(t *testing.T) {
+	var output bytes.Buffer
+	source := NewFileSource(strings.NewReader(`{"payload":"hello"}`+"\n"+`{"payload":"world"}`+"\n"), "in")
+	runner, err := NewRunnerWith(Config{}, source, NewFileSink(&output))
+	assert.Equal(t, nil, err)
+
+	err = runner.Run(context.Background(), "../scripts/exec.sh")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(source.Acked()))
+	assert.Equal(t, `{"payload":"hello!"}`+"\n"+`{"payload":"world!"}`+"\n", output.String())
+}
+
+func TestRunner_RunFileInvalid(t *testing.T) {
+	var output bytes.Buffer
+	input := `{"payload":"hello"}` + "\n" + `not json` + "\n" + `{"payload":"world"}` + "\n"
+	source := NewFileSource(strings.NewReader(input), "in")
+	runner, err := NewRunnerWith(Config{}, source, NewFileSink(&output))
+	assert.Equal(t, nil, err)
+
+	// the replay fails instead of skipping the rest of the file silently
+	err = runner.Run(context.Background(), "../scripts/exec.sh")
+	assert.ErrorContains(t, err, "source is closed: invalid message at line 2")
+	assert.Equal(t, 1, len(source.Acked()))
+	assert.Equal(t, `{"payload":"hello!"}`+"\n", output.String())
+}
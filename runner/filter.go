@@ -0,0 +1,32 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"sync/atomic"
+)
+
+// FilterPolicy decides when the script drops a message, a filtered message is acked without sending any output
+type FilterPolicy struct {
+	// EmptyOutput filters the message when the script prints nothing
+	EmptyOutput bool
+	// ExitCode filters the message when the script exits with it, 0 disables it
+	ExitCode int
+}
+
+// filtersExit reports whether the script exits with the reserved exit code
+func (policy FilterPolicy) filtersExit(err error) bool {
+	return policy.ExitCode != 0 && common.ExitCode(err) == policy.ExitCode
+}
+
+// filtersOutput reports whether there is nothing to send, records in the jsonl and fd output modes are optional
+func (policy FilterPolicy) filtersOutput(stdout []byte, outputs []output) bool {
+	return len(outputs) == 0 || policy.EmptyOutput && len(stdout) == 0
+}
+
+// filter acks the message without sending any output
+func (runner *Runner) filter(msg Message, reason string) {
+	filtered := atomic.AddUint64(&runner.filtered, 1)
+	runner.observeFiltered(msg)
+	runner.messageLog(msg).Infof("message is filtered by %s, %d messages filtered in total", reason, filtered)
+	runner.source.Ack(msg)
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRunner_Filter(t *testing.T) {
+	tests := []struct {
+		name           string
+		payload        string
+		policy         FilterPolicy
+		outputMode     OutputMode
+		expectAcks     int
+		expectNacks    int
+		expectSent     int
+		expectFiltered uint64
+	}{
+		{
+			name:           "it should filter the message by the reserved exit code",
+			payload:        "drop",
+			policy:         FilterPolicy{ExitCode: 3},
+			expectAcks:     1,
+			expectFiltered: 1,
+		},
+		{
+			name:        "it should fail the message when the exit code is not reserved",
+			payload:     "drop",
+			policy:      FilterPolicy{},
+			expectNacks: 1,
+		},
+		{
+			name:           "it should filter the message when the output is empty",
+			payload:        "empty",
+			policy:         FilterPolicy{EmptyOutput: true},
+			expectAcks:     1,
+			expectFiltered: 1,
+		},
+		{
+			name:       "it should send the empty output when empty output is not filtered",
+			payload:    "empty",
+			policy:     FilterPolicy{},
+			expectAcks: 1,
+			expectSent: 1,
+		},
+		{
+			name:           "it should filter the message when there is no output record",
+			payload:        "empty",
+			policy:         FilterPolicy{},
+			outputMode:     OutputModeJSONL,
+			expectAcks:     1,
+			expectFiltered: 1,
+		},
+		{
+			name:       "it should send the output when it's not filtered",
+			payload:    "hello",
+			policy:     FilterPolicy{EmptyOutput: true, ExitCode: 3},
+			expectAcks: 1,
+			expectSent: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newMemorySource(&mockMessage{payload: []byte(tt.payload)})
+			sink := NewMemorySink()
+			runner := &Runner{
+				source:       source,
+				sink:         sink,
+				logger:       logrus.New(),
+				execOptions:  execOptions{outputMode: tt.outputMode},
+				filterPolicy: tt.policy,
+			}
+
+			err := runner.Run(context.Background(), "../scripts/filter.sh")
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tt.expectAcks, len(source.Acked()))
+			assert.Equal(t, tt.expectNacks, len(source.Nacked()))
+			assert.Equal(t, tt.expectSent, len(sink.Sent("")))
+			assert.Equal(t, tt.expectFiltered, runner.filtered)
+		})
+	}
+}
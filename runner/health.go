@@ -0,0 +1,171 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// connectionCheckInterval is how often the connection to pulsar is checked for the readiness
+const connectionCheckInterval = 10 * time.Second
+
+// progress tracks the messages received but not acked or nacked yet, and when the last of them moved forward
+type progress struct {
+	mu      sync.Mutex
+	pending int
+	// held is the number of the pending messages waiting in the open windows, they're expected to make no progress
+	held int
+	last time.Time
+	now  func() time.Time
+}
+
+func newProgress() *progress {
+	return &progress{last: time.Now(), now: time.Now}
+}
+
+func (p *progress) received() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending++
+	p.last = p.now()
+}
+
+func (p *progress) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending > 0 {
+		p.pending--
+	}
+	p.last = p.now()
+}
+
+// hold sets the number of the pending messages waiting in the open windows, a change of it is a progress
+func (p *progress) hold(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n != p.held {
+		p.held = n
+		p.last = p.now()
+	}
+}
+
+// stalled returns an error when there are pending messages not held by the windows but nothing moved forward within
+// the timeout
+func (p *progress) stalled(timeout time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	active := p.pending - p.held
+	if idle := p.now().Sub(p.last); active > 0 && idle > timeout {
+		return fmt.Errorf("no progress in %s with %d pending messages", idle.Round(time.Second), active)
+	}
+	return nil
+}
+
+// progressSource reports the received and finished messages of the source to the progress
+type progressSource struct {
+	Source
+	progress *progress
+}
+
+func (source *progressSource) Receive(ctx context.Context) (Message, error) {
+	msg, err := source.Source.Receive(ctx)
+	if err == nil {
+		source.progress.received()
+	}
+	return msg, err
+}
+
+func (source *progressSource) Ack(msg Message) {
+	source.Source.Ack(msg)
+	source.progress.done()
+}
+
+func (source *progressSource) Nack(msg Message) {
+	source.Source.Nack(msg)
+	source.progress.done()
+}
+
+func (source *progressSource) ReconsumeLater(msg Message, delay time.Duration) {
+	source.Source.ReconsumeLater(msg, delay)
+	source.progress.done()
+}
+
+// connectionChecker checks the connection to pulsar periodically, it's assumed to be up until a check fails
+type connectionChecker struct {
+	mu     sync.Mutex
+	err    error
+	check  func() error
+	logger *logrus.Logger
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func startConnectionChecker(check func() error, interval time.Duration, logger *logrus.Logger) *connectionChecker {
+	checker := &connectionChecker{
+		check:  check,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				checker.update(checker.check())
+			case <-checker.stop:
+				return
+			}
+		}
+	}()
+	return checker
+}
+
+// update records the result of a check, and logs when the connection goes down or comes back
+func (checker *connectionChecker) update(err error) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	switch {
+	case err != nil && checker.err == nil:
+		checker.logger.Warnf("pulsar connection is down: %s", err)
+	case err == nil && checker.err != nil:
+		checker.logger.Infof("pulsar connection is restored")
+	}
+	checker.err = err
+}
+
+// status returns the error of the last check
+func (checker *connectionChecker) status() error {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+	return checker.err
+}
+
+func (checker *connectionChecker) close() {
+	checker.once.Do(func() {
+		close(checker.stop)
+	})
+}
+
+// Ready returns an error when the runner can't process messages because the connection to pulsar is down.
+// The runners not created by NewRunner are always ready.
+func (runner *Runner) Ready() error {
+	if runner.connection == nil {
+		return nil
+	}
+	if err := runner.connection.status(); err != nil {
+		return fmt.Errorf("pulsar connection is down: %s", err)
+	}
+	return nil
+}
+
+// Live returns an error when the runner has pending messages but hasn't acked or nacked any of them, nor received a
+// new one, within the LivenessTimeout. It's always live when the LivenessTimeout is zero.
+func (runner *Runner) Live() error {
+	if runner.livenessTimeout <= 0 || runner.progress == nil {
+		return nil
+	}
+	return runner.progress.stalled(runner.livenessTimeout)
+}
@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRunner_Live(t *testing.T) {
+	tests := []struct {
+		name            string
+		livenessTimeout time.Duration
+		pending         int
+		held            int
+		idle            time.Duration
+		expectError     bool
+	}{
+		{
+			name:            "it should be live when the pending messages make progress",
+			livenessTimeout: time.Minute,
+			pending:         2,
+			idle:            30 * time.Second,
+		},
+		{
+			name:            "it should be live when there is no pending message",
+			livenessTimeout: time.Minute,
+			idle:            time.Hour,
+		},
+		{
+			name:            "it should not be live when the pending messages make no progress",
+			livenessTimeout: time.Minute,
+			pending:         1,
+			idle:            2 * time.Minute,
+			expectError:     true,
+		},
+		{
+			name:            "it should be live when the pending messages are held in the windows",
+			livenessTimeout: time.Minute,
+			pending:         2,
+			held:            2,
+			idle:            2 * time.Minute,
+		},
+		{
+			name:    "it should be live when the check is disabled",
+			pending: 1,
+			idle:    time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			runner := &Runner{
+				progress: &progress{
+					pending: tt.pending,
+					held:    tt.held,
+					last:    now.Add(-tt.idle),
+					now:     func() time.Time { return now },
+				},
+				livenessTimeout: tt.livenessTimeout,
+			}
+			assert.Equal(t, tt.expectError, runner.Live() != nil)
+		})
+	}
+}
+
+func TestProgressSource(t *testing.T) {
+	source := newMemorySource(&mockMessage{id: "1:1:-1:-1"}, &mockMessage{id: "1:2:-1:-1"}, &mockMessage{id: "1:3:-1:-1"})
+	p := newProgress()
+	tracked := &progressSource{Source: source, progress: p}
+	var msgs []Message
+	for i := 0; i < 3; i++ {
+		msg, err := tracked.Receive(context.Background())
+		require.NoError(t, err)
+		msgs = append(msgs, msg)
+	}
+	assert.Equal(t, 3, p.pending)
+
+	tracked.Ack(msgs[0])
+	tracked.Nack(msgs[1])
+	tracked.ReconsumeLater(msgs[2], time.Second)
+	assert.Equal(t, 0, p.pending)
+	assert.Len(t, source.Acked(), 1)
+	assert.Len(t, source.Nacked(), 1)
+	assert.Len(t, source.Reconsumed(), 1)
+}
+
+func TestRunner_Ready(t *testing.T) {
+	checks := make(chan error)
+	checker := startConnectionChecker(func() error {
+		return <-checks
+	}, time.Millisecond, logrus.New())
+	defer checker.close()
+	runner := &Runner{connection: checker}
+	assert.NoError(t, runner.Ready())
+
+	checks <- errors.New("connection refused")
+	// the result is recorded after the check returns
+	checks <- errors.New("connection refused")
+	assert.EqualError(t, runner.Ready(), "pulsar connection is down: connection refused")
+
+	checks <- nil
+	checks <- nil
+	assert.NoError(t, runner.Ready())
+
+	assert.NoError(t, (&Runner{}).Ready())
+}
@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// InputMode decides how the message payload is passed to the script
+type InputMode string
+
+const (
+	// InputModeArgv passes the payload as the first argument, it can't carry NUL bytes or large payloads
+	InputModeArgv InputMode = "argv"
+	// InputModeStdin streams the payload to the stdin of the script
+	InputModeStdin InputMode = "stdin"
+	// InputModeFile writes the payload to a temp file, and passes the file path as the first argument
+	InputModeFile InputMode = "file"
+)
+
+// parseInputMode parses the input mode name, argv is used when it's empty to keep the existing scripts working
+func parseInputMode(name string) (InputMode, error) {
+	switch mode := InputMode(strings.ToLower(name)); mode {
+	case "":
+		return InputModeArgv, nil
+	case InputModeArgv, InputModeStdin, InputModeFile:
+		return mode, nil
+	default:
+		return InputModeArgv, fmt.Errorf("unknown input mode '%s', should be one of argv, stdin and file", name)
+	}
+}
+
+// newCommand creates the command which passes the payload to the script in the given mode,
+// the returned cleanup function should be called after the command exits
+func newCommand(file string, payload []byte, mode InputMode) (*exec.Cmd, func(), error) {
+	switch mode {
+	case InputModeStdin:
+		cmd := exec.Command(file)
+		cmd.Stdin = bytes.NewReader(payload)
+		return cmd, func() {}, nil
+	case InputModeFile:
+		tmp, err := os.CreateTemp("", "bash-runtime-payload-*")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup := func() {
+			_ = os.Remove(tmp.Name())
+		}
+		_, err = tmp.Write(payload)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return exec.Command(file, tmp.Name()), cleanup, nil
+	default:
+		return exec.Command(file, string(payload)), func() {}, nil
+	}
+}
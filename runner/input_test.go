@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseInputMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    InputMode
+		wantErr bool
+	}{
+		{
+			name:  "it should use argv by default",
+			value: "",
+			want:  InputModeArgv,
+		},
+		{
+			name:  "it should parse stdin mode",
+			value: "stdin",
+			want:  InputModeStdin,
+		},
+		{
+			name:  "it should parse file mode",
+			value: "FILE",
+			want:  InputModeFile,
+		},
+		{
+			name:    "it should fail to parse unknown mode",
+			value:   "env",
+			want:    InputModeArgv,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInputMode(tt.value)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestExecScript_InputMode(t *testing.T) {
+	binary := []byte("hello\x00world\xff")
+	large := bytes.Repeat([]byte("a"), 1<<20)
+	tests := []struct {
+		name         string
+		script       string
+		payload      []byte
+		mode         InputMode
+		expectStdout []byte
+		expectError  error
+	}{
+		{
+			name:         "it should pass the payload by stdin",
+			script:       "../scripts/exec.sh",
+			payload:      []byte("hello world"),
+			mode:         InputModeStdin,
+			expectStdout: []byte("hello world!"),
+		},
+		{
+			name:         "it should pass binary payload by stdin",
+			script:       "../scripts/cat.sh",
+			payload:      binary,
+			mode:         InputModeStdin,
+			expectStdout: binary,
+		},
+		{
+			name:         "it should pass large payload by stdin",
+			script:       "../scripts/cat.sh",
+			payload:      large,
+			mode:         InputModeStdin,
+			expectStdout: large,
+		},
+		{
+			name:         "it should pass binary payload by file",
+			script:       "../scripts/file.sh",
+			payload:      binary,
+			mode:         InputModeFile,
+			expectStdout: binary,
+		},
+		{
+			name:        "it should fail to pass large payload by argv",
+			script:      "../scripts/exec.sh",
+			payload:     large,
+			mode:        InputModeArgv,
+			expectError: common.ErrScriptExecError,
+		},
+		{
+			name:         "it should run the script when it doesn't read the stdin",
+			script:       "../scripts/stderr.sh",
+			payload:      large,
+			mode:         InputModeStdin,
+			expectStdout: []byte("!"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, _, err := execScript(context.Background(), tt.script, tt.payload, nil, execOptions{inputMode: tt.mode})
+			assert.Equal(t, tt.expectStdout, stdout)
+			assert.Equal(t, true, errors.Is(err, tt.expectError))
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// LogConfig decides how the logs are formatted and how much of the payloads they carry
+type LogConfig struct {
+	// Format is one of text and json, text is used when it's empty
+	Format string
+	// Level is one of trace, debug, info, warn and error, info is used when it's empty
+	Level string
+	// Payload is one of off, truncated and full, off is used when it's empty
+	Payload string
+}
+
+// PayloadLogging decides how the payload of a message is put in its logs, the size and the sha256 of the payload are
+// always logged so that the logs of a same payload can be correlated
+type PayloadLogging string
+
+const (
+	// PayloadLoggingOff never logs the payload, which may carry personal data
+	PayloadLoggingOff PayloadLogging = "off"
+	// PayloadLoggingTruncated logs the first payloadPreviewSize bytes of the payload
+	PayloadLoggingTruncated PayloadLogging = "truncated"
+	// PayloadLoggingFull logs the whole payload
+	PayloadLoggingFull PayloadLogging = "full"
+)
+
+// payloadPreviewSize is how many bytes of the payload are logged in the truncated payload logging
+const payloadPreviewSize = 256
+
+// parsePayloadLogging parses the payload logging name, off is used when it's empty
+func parsePayloadLogging(name string) (PayloadLogging, error) {
+	switch logging := PayloadLogging(strings.ToLower(name)); logging {
+	case "":
+		return PayloadLoggingOff, nil
+	case PayloadLoggingOff, PayloadLoggingTruncated, PayloadLoggingFull:
+		return logging, nil
+	default:
+		return PayloadLoggingOff, fmt.Errorf("unknown payload logging '%s', should be one of off, truncated and full", name)
+	}
+}
+
+// SetupLogging applies the format and the level of the config to the standard logger, so that the logs out of the
+// runner are formatted like its own
+func SetupLogging(config LogConfig) error {
+	return configureLogger(logrus.StandardLogger(), config)
+}
+
+// newLogger creates the logger of a runner, it writes to the output of the standard logger
+func newLogger(config LogConfig) (*logrus.Logger, error) {
+	logger := logrus.New()
+	logger.SetOutput(logrus.StandardLogger().Out)
+	if err := configureLogger(logger, config); err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
+
+func configureLogger(logger *logrus.Logger, config LogConfig) error {
+	switch strings.ToLower(config.Format) {
+	case "", "text":
+		logger.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format '%s', should be one of text and json", config.Format)
+	}
+	level := logrus.InfoLevel
+	if config.Level != "" {
+		var err error
+		if level, err = logrus.ParseLevel(config.Level); err != nil {
+			return fmt.Errorf("unknown log level '%s', should be one of trace, debug, info, warn and error", config.Level)
+		}
+	}
+	logger.SetLevel(level)
+	return nil
+}
+
+// messageLog returns the log entry with the fields correlating it to the message, the attempt is 1 for the first
+// delivery of the message, and it counts the redeliveries by the retry letter topic
+func (runner *Runner) messageLog(msg Message) *logrus.Entry {
+	payload := msg.Payload()
+	hash := sha256.Sum256(payload)
+	fields := logrus.Fields{
+		"message_id":     msg.ID(),
+		"topic":          sourceTopic(msg),
+		"attempt":        redeliveries(msg) + 1,
+		"payload_size":   len(payload),
+		"payload_sha256": hex.EncodeToString(hash[:]),
+	}
+	if key := msg.Key(); key != "" {
+		fields["key"] = key
+	}
+	switch runner.payloadLogging {
+	case PayloadLoggingTruncated:
+		fields["payload"] = payloadPreview(payload)
+	case PayloadLoggingFull:
+		fields["payload"] = string(payload)
+	}
+	return runner.logger.WithFields(fields)
+}
+
+// logFailure logs the failure of a batch or a window once per message, so that every log carries the fields of its
+// message along with the given ones
+func (runner *Runner) logFailure(msgs []Message, fields logrus.Fields, format string, args ...interface{}) {
+	for _, msg := range msgs {
+		runner.messageLog(msg).WithFields(fields).Errorf(format, args...)
+	}
+}
+
+// scriptFields returns the fields of how a run of the script ends
+func scriptFields(err error, duration time.Duration) logrus.Fields {
+	return logrus.Fields{
+		"exit_code":   common.ExitCode(err),
+		"duration_ms": float64(duration) / float64(time.Millisecond),
+	}
+}
+
+// payloadPreview returns the first payloadPreviewSize bytes of the payload
+func payloadPreview(payload []byte) string {
+	return preview(payload, payloadPreviewSize)
+}
+
+// preview returns the first size bytes of the data, it's cut at a rune boundary and ends with ... when it's truncated
+func preview(data []byte, size int) string {
+	if len(data) <= size {
+		return string(data)
+	}
+	end := size
+	for end > 0 && !utf8.RuneStart(data[end]) {
+		end--
+	}
+	return string(data[:end]) + "..."
+}
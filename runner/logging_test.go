@@ -0,0 +1,201 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jsonLogs returns the JSON log entries whose messages start with the prefix
+func jsonLogs(t *testing.T, output *bytes.Buffer, prefix string) []map[string]interface{} {
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		if message, _ := entry["msg"].(string); strings.HasPrefix(message, prefix) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestRunner_MessageLog(t *testing.T) {
+	long := strings.Repeat("a", payloadPreviewSize+10)
+	tests := []struct {
+		name          string
+		payload       string
+		logging       string
+		expectPayload interface{}
+	}{
+		{
+			name:    "it should not log the payload by default",
+			payload: "secret",
+		},
+		{
+			name:          "it should log the truncated payload",
+			payload:       long,
+			logging:       "truncated",
+			expectPayload: long[:payloadPreviewSize] + "...",
+		},
+		{
+			name:          "it should log the full payload",
+			payload:       long,
+			logging:       "full",
+			expectPayload: long,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newMemorySource(&mockMessage{id: "1:1:-1:-1", topic: "in", key: "k", redeliveryCount: 2,
+				payload: []byte(tt.payload)})
+			runner, err := NewRunnerWith(Config{Log: LogConfig{Format: "json", Payload: tt.logging}}, source, NewMemorySink())
+			require.NoError(t, err)
+			var output bytes.Buffer
+			runner.logger.SetOutput(&output)
+			require.NoError(t, runner.Run(context.Background(), "../scripts/exec.sh"))
+
+			entries := jsonLogs(t, &output, "process message successfully")
+			require.Len(t, entries, 1)
+			entry := entries[0]
+			assert.Equal(t, "info", entry["level"])
+			assert.Equal(t, "1:1:-1:-1", entry["message_id"])
+			assert.Equal(t, "in", entry["topic"])
+			assert.Equal(t, "k", entry["key"])
+			assert.Equal(t, float64(3), entry["attempt"])
+			assert.Equal(t, float64(0), entry["exit_code"])
+			assert.Contains(t, entry, "duration_ms")
+			assert.Equal(t, float64(1), entry["outputs"])
+			assert.Equal(t, float64(len(tt.payload)), entry["payload_size"])
+			assert.Len(t, entry["payload_sha256"], 64)
+			assert.Equal(t, tt.expectPayload, entry["payload"])
+			assert.NotContains(t, output.String(), "secret")
+		})
+	}
+}
+
+func TestRunner_MessageLogFailure(t *testing.T) {
+	source := newMemorySource(&mockMessage{id: "1:1:-1:-1", topic: "in", payload: []byte("a")})
+	runner, err := NewRunnerWith(Config{Log: LogConfig{Format: "json"}}, source, NewMemorySink())
+	require.NoError(t, err)
+	var output bytes.Buffer
+	runner.logger.SetOutput(&output)
+	require.NoError(t, runner.Run(context.Background(), "../scripts/exit.sh"))
+
+	entries := jsonLogs(t, &output, "failed to process message")
+	require.Len(t, entries, 1)
+	assert.Equal(t, "error", entries[0]["level"])
+	assert.Equal(t, "1:1:-1:-1", entries[0]["message_id"])
+	assert.Equal(t, float64(1), entries[0]["attempt"])
+	assert.Equal(t, float64(1), entries[0]["exit_code"])
+}
+
+func TestRunner_MessageLogAttempt(t *testing.T) {
+	// the retry letter topic redelivers a message as a new one, which carries the reconsume times in the properties
+	source := newMemorySource(&mockMessage{id: "1:1:-1:-1", topic: "retry", payload: []byte("a"),
+		properties: map[string]string{pulsar.SysPropertyReconsumeTimes: "2"}})
+	runner, err := NewRunnerWith(Config{Log: LogConfig{Format: "json"}}, source, NewMemorySink())
+	require.NoError(t, err)
+	var output bytes.Buffer
+	runner.logger.SetOutput(&output)
+	require.NoError(t, runner.Run(context.Background(), "../scripts/exit.sh"))
+
+	entries := jsonLogs(t, &output, "failed to process message")
+	require.Len(t, entries, 1)
+	assert.Equal(t, float64(3), entries[0]["attempt"])
+}
+
+func TestRunner_MessageLogGroupFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		prefix string
+	}{
+		{
+			name:   "it should log the failure of a batch for every message",
+			config: Config{InputMode: "stdin", OutputMode: "jsonl", Batch: BatchPolicy{MaxMessages: 2, MaxDelay: time.Minute}},
+			prefix: "failed to process batch of 2 messages",
+		},
+		{
+			name:   "it should log the failure of a window for every message",
+			config: Config{InputMode: "stdin", Window: WindowPolicy{LengthCount: 2}},
+			prefix: "failed to process window of 2 messages",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newMemorySource(
+				&mockMessage{id: "1:1:-1:-1", topic: "in", payload: []byte("a")},
+				&mockMessage{id: "1:2:-1:-1", topic: "in", payload: []byte("b")},
+			)
+			tt.config.Log = LogConfig{Format: "json"}
+			runner, err := NewRunnerWith(tt.config, source, NewMemorySink())
+			require.NoError(t, err)
+			var output bytes.Buffer
+			runner.logger.SetOutput(&output)
+			require.NoError(t, runner.Run(context.Background(), "../scripts/exit.sh"))
+
+			entries := jsonLogs(t, &output, tt.prefix)
+			require.Len(t, entries, 2)
+			for i, id := range []string{"1:1:-1:-1", "1:2:-1:-1"} {
+				assert.Equal(t, "error", entries[i]["level"])
+				assert.Equal(t, id, entries[i]["message_id"])
+				assert.Equal(t, "in", entries[i]["topic"])
+				assert.Equal(t, float64(1), entries[i]["exit_code"])
+				assert.Contains(t, entries[i], "duration_ms")
+			}
+		})
+	}
+}
+
+func TestNewRunnerWith_LogConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    LogConfig
+		expectErr string
+	}{
+		{
+			name:   "it should accept the log config",
+			config: LogConfig{Format: "JSON", Level: "debug", Payload: "truncated"},
+		},
+		{
+			name:      "it should reject an unknown format",
+			config:    LogConfig{Format: "xml"},
+			expectErr: "unknown log format 'xml', should be one of text and json",
+		},
+		{
+			name:      "it should reject an unknown level",
+			config:    LogConfig{Level: "verbose"},
+			expectErr: "unknown log level 'verbose', should be one of trace, debug, info, warn and error",
+		},
+		{
+			name:      "it should reject an unknown payload logging",
+			config:    LogConfig{Payload: "partial"},
+			expectErr: "unknown payload logging 'partial', should be one of off, truncated and full",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRunnerWith(Config{Log: tt.config}, newMemorySource(), NewMemorySink())
+			if tt.expectErr != "" {
+				assert.EqualError(t, err, tt.expectErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPayloadPreview(t *testing.T) {
+	assert.Equal(t, "short", payloadPreview([]byte("short")))
+	// the rune crossing the limit is not cut in half
+	payload := strings.Repeat("a", payloadPreviewSize-1) + "é"
+	assert.Equal(t, strings.Repeat("a", payloadPreviewSize-1)+"...", payloadPreview([]byte(payload+"tail")))
+}
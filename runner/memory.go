@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// MemorySource delivers the messages added to it, the messages are never redelivered
+type MemorySource struct {
+	ackRecorder
+	messages chan Message
+	endOnce  sync.Once
+}
+
+// NewMemorySource creates a source which holds up to size messages not received yet
+func NewMemorySource(size int) *MemorySource {
+	return &MemorySource{messages: make(chan Message, size)}
+}
+
+// Add queues the messages, it blocks when the source is full
+func (source *MemorySource) Add(msgs ...Message) {
+	for _, msg := range msgs {
+		source.messages <- msg
+	}
+}
+
+// End tells no more messages will be added, Receive returns io.EOF after the queued messages are received
+func (source *MemorySource) End() {
+	source.endOnce.Do(func() {
+		close(source.messages)
+	})
+}
+
+func (source *MemorySource) Receive(ctx context.Context) (Message, error) {
+	select {
+	case msg, ok := <-source.messages:
+		if !ok {
+			return nil, io.EOF
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (source *MemorySource) Close() {
+	source.End()
+}
+
+// MemorySink keeps the messages sent to it by their topics
+type MemorySink struct {
+	mu       sync.Mutex
+	sent     map[string][]*OutputMessage
+	attempts int
+	err      error
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{sent: map[string][]*OutputMessage{}}
+}
+
+// FailWith makes every following Send fail with the error, nil makes it succeed again
+func (sink *MemorySink) FailWith(err error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.err = err
+}
+
+func (sink *MemorySink) Send(_ context.Context, topic string, msg *OutputMessage) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.attempts++
+	if sink.err != nil {
+		return sink.err
+	}
+	sink.sent[topic] = append(sink.sent[topic], msg)
+	return nil
+}
+
+// Sent returns the messages sent to the topic, an empty topic means the default output topic
+func (sink *MemorySink) Sent(topic string) []*OutputMessage {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return append([]*OutputMessage(nil), sink.sent[topic]...)
+}
+
+// Attempts returns the number of Send calls, including the failed ones
+func (sink *MemorySink) Attempts() int {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.attempts
+}
+
+func (sink *MemorySink) Flush() error {
+	return nil
+}
+
+func (sink *MemorySink) Close() {}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// environment variables exposing the message metadata to the script
+const (
+	EnvMessageID    = "PULSAR_MSG_ID"
+	EnvMessageTopic = "PULSAR_MSG_TOPIC"
+	// EnvMessageTopicName is the short name of the topic without the domain, tenant, namespace and partition
+	EnvMessageTopicName       = "PULSAR_MSG_TOPIC_NAME"
+	EnvMessagePublishTime     = "PULSAR_MSG_PUBLISH_TIME"
+	EnvMessageEventTime       = "PULSAR_MSG_EVENT_TIME"
+	EnvMessageKey             = "PULSAR_MSG_KEY"
+	EnvMessageRedeliveryCount = "PULSAR_MSG_REDELIVERY_COUNT"
+	EnvMessageProducerName    = "PULSAR_MSG_PRODUCER_NAME"
+	// EnvPropertyPrefix is followed by the sanitized property name
+	EnvPropertyPrefix = "PULSAR_PROP_"
+)
+
+// lineage properties telling where a message comes from
+const (
+	PropertySourceTopic     = "source-topic"
+	PropertySourceMessageID = "source-message-id"
+)
+
+var (
+	invalidEnvChars = regexp.MustCompile("[^A-Za-z0-9_]")
+	partitionSuffix = regexp.MustCompile(`-partition-\d+$`)
+)
+
+// messageEnv returns the metadata and user properties of the message as environment variables
+func messageEnv(msg Message) []string {
+	env := []string{
+		EnvMessageID + "=" + msg.ID(),
+		EnvMessageTopic + "=" + sourceTopic(msg),
+		EnvMessageTopicName + "=" + topicName(sourceTopic(msg)),
+		EnvMessagePublishTime + "=" + formatTime(msg.PublishTime()),
+		EnvMessageEventTime + "=" + formatTime(msg.EventTime()),
+		EnvMessageKey + "=" + msg.Key(),
+		EnvMessageRedeliveryCount + "=" + strconv.FormatUint(uint64(msg.RedeliveryCount()), 10),
+		EnvMessageProducerName + "=" + msg.ProducerName(),
+	}
+
+	// sort the properties so that the result is stable when sanitized names conflict
+	properties := msg.Properties()
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, EnvPropertyPrefix+sanitizeEnvName(name)+"="+properties[name])
+	}
+	return env
+}
+
+// sanitizeEnvName replaces the characters which are not allowed in shell variable names with underscores
+func sanitizeEnvName(name string) string {
+	return invalidEnvChars.ReplaceAllString(name, "_")
+}
+
+// formatTime formats the time as RFC3339 in UTC, zero time means it's not set
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// sourceTopic returns the topic the message is originally published to, messages from the retry letter topic
+// carry their original topic in the properties
+func sourceTopic(msg Message) string {
+	if realTopic, ok := msg.Properties()[pulsar.SysPropertyRealTopic]; ok {
+		return realTopic
+	}
+	return msg.Topic()
+}
+
+// redeliveries returns how many times the message has been redelivered after failures, either by nack or by the retry
+// letter topic, which carries the times in the properties
+func redeliveries(msg Message) uint32 {
+	redeliveries := msg.RedeliveryCount()
+	if times, ok := msg.Properties()[pulsar.SysPropertyReconsumeTimes]; ok {
+		if n, err := strconv.ParseUint(times, 10, 32); err == nil && uint32(n) > redeliveries {
+			redeliveries = uint32(n)
+		}
+	}
+	return redeliveries
+}
+
+// topicName returns the short name of the topic, e.g. orders-eu for persistent://public/default/orders-eu-partition-0
+func topicName(topic string) string {
+	if i := strings.LastIndex(topic, "/"); i >= 0 {
+		topic = topic[i+1:]
+	}
+	return partitionSuffix.ReplaceAllString(topic, "")
+}
@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMessageEnv(t *testing.T) {
+	publishTime := time.Date(2022, 4, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		msg  *mockMessage
+		want []string
+	}{
+		{
+			name: "it should export the metadata and properties of the message",
+			msg: &mockMessage{
+				id:              "10:1:-1:-1",
+				topic:           "persistent://public/default/in",
+				key:             "customer-1",
+				properties:      map[string]string{"trace-id": "abc", "1.retry": "2"},
+				redeliveryCount: 1,
+				producerName:    "producer-1",
+				publishTime:     publishTime,
+				eventTime:       publishTime.Add(-time.Second),
+			},
+			want: []string{
+				"PULSAR_MSG_ID=10:1:-1:-1",
+				"PULSAR_MSG_TOPIC=persistent://public/default/in",
+				"PULSAR_MSG_TOPIC_NAME=in",
+				"PULSAR_MSG_PUBLISH_TIME=2022-04-01T08:00:00Z",
+				"PULSAR_MSG_EVENT_TIME=2022-04-01T07:59:59Z",
+				"PULSAR_MSG_KEY=customer-1",
+				"PULSAR_MSG_REDELIVERY_COUNT=1",
+				"PULSAR_MSG_PRODUCER_NAME=producer-1",
+				"PULSAR_PROP_1_retry=2",
+				"PULSAR_PROP_trace_id=abc",
+			},
+		},
+		{
+			name: "it should export the original topic of the message from retry letter topic",
+			msg: &mockMessage{
+				id:          "10:2:-1:-1",
+				topic:       "persistent://public/default/retry",
+				properties:  map[string]string{pulsar.SysPropertyRealTopic: "persistent://public/default/in"},
+				publishTime: publishTime,
+			},
+			want: []string{
+				"PULSAR_MSG_ID=10:2:-1:-1",
+				"PULSAR_MSG_TOPIC=persistent://public/default/in",
+				"PULSAR_MSG_TOPIC_NAME=in",
+				"PULSAR_MSG_PUBLISH_TIME=2022-04-01T08:00:00Z",
+				"PULSAR_MSG_EVENT_TIME=",
+				"PULSAR_MSG_KEY=",
+				"PULSAR_MSG_REDELIVERY_COUNT=0",
+				"PULSAR_MSG_PRODUCER_NAME=",
+				"PULSAR_PROP_REAL_TOPIC=persistent://public/default/in",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, messageEnv(tt.msg))
+		})
+	}
+}
+
+func TestExecScript_Env(t *testing.T) {
+	msg := &mockMessage{
+		topic:      "persistent://public/default/in",
+		key:        "customer-1",
+		properties: map[string]string{"trace-id": "abc"},
+	}
+	stdout, _, err := execScript(context.Background(), "../scripts/env.sh", nil, messageEnv(msg), execOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "persistent://public/default/in customer-1 abc", string(stdout))
+}
+
+func TestTopicName(t *testing.T) {
+	assert.Equal(t, "orders-eu", topicName("persistent://public/default/orders-eu"))
+	assert.Equal(t, "orders-eu", topicName("persistent://public/default/orders-eu-partition-12"))
+	assert.Equal(t, "orders", topicName("orders"))
+}
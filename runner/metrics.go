@@ -0,0 +1,190 @@
+package runner
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const metricsNamespace = "bash_runtime"
+
+// metrics of the runner, every runner has its own registry so that the runners don't share the counters. A runner
+// without metrics, e.g. one not created by NewRunnerWith, records nothing, they're only recorded by its observe methods.
+type metrics struct {
+	registry *prometheus.Registry
+	// the message counters are labelled by the input topic
+	received     *prometheus.CounterVec
+	succeeded    *prometheus.CounterVec
+	failed       *prometheus.CounterVec
+	filtered     *prometheus.CounterVec
+	deadLettered *prometheus.CounterVec
+	retried      *prometheus.CounterVec
+	// sendFailures is labelled by the output topic, it's counted when the retries of the send give up
+	sendFailures   *prometheus.CounterVec
+	scriptDuration prometheus.Histogram
+	latency        prometheus.Histogram
+	payloadSize    prometheus.Histogram
+	outputSize     prometheus.Histogram
+	inFlight       prometheus.Gauge
+	workerRestarts prometheus.Gauge
+}
+
+// sizeBuckets are from 64B to 4MB
+var sizeBuckets = prometheus.ExponentialBuckets(64, 4, 9)
+
+func newMetrics() *metrics {
+	messageCounter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      name,
+			Help:      help,
+		}, []string{"topic"})
+	}
+	m := &metrics{
+		registry:     prometheus.NewRegistry(),
+		received:     messageCounter("messages_received_total", "The number of the messages received."),
+		succeeded:    messageCounter("messages_succeeded_total", "The number of the messages whose outputs are sent."),
+		failed:       messageCounter("messages_failed_total", "The number of the messages the script failed to process."),
+		filtered:     messageCounter("messages_filtered_total", "The number of the messages filtered without any output."),
+		deadLettered: messageCounter("messages_dead_lettered_total", "The number of the messages sent to the dead letter topic."),
+		retried:      messageCounter("messages_retried_total", "The number of the failed messages redelivered for retry."),
+		sendFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "send_failures_total",
+			Help:      "The number of the messages which can't be sent after the retries.",
+		}, []string{"topic"}),
+		scriptDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "script_duration_seconds",
+			Help:      "How long the script runs.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "end_to_end_latency_seconds",
+			Help:      "The time from a message is published to its outputs are sent.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+		}),
+		payloadSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "payload_size_bytes",
+			Help:      "The size of the payloads of the received messages.",
+			Buckets:   sizeBuckets,
+		}),
+		outputSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "output_size_bytes",
+			Help:      "The size of the payloads of the sent outputs.",
+			Buckets:   sizeBuckets,
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "scripts_in_flight",
+			Help:      "The number of the scripts running.",
+		}),
+		workerRestarts: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "worker_restarts",
+			Help:      "The number of the restarts of the worker processes in the worker exec mode.",
+		}),
+	}
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.received, m.succeeded, m.failed, m.filtered, m.deadLettered, m.retried, m.sendFailures,
+		m.scriptDuration, m.latency, m.payloadSize, m.outputSize, m.inFlight, m.workerRestarts,
+	)
+	return m
+}
+
+// MetricsHandler serves the metrics of the runner in the prometheus format
+func (runner *Runner) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(runner.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// observeReceived counts the message when it's received
+func (runner *Runner) observeReceived(msg Message) {
+	if runner.metrics == nil {
+		return
+	}
+	runner.metrics.received.WithLabelValues(sourceTopic(msg)).Inc()
+	runner.metrics.payloadSize.Observe(float64(len(msg.Payload())))
+}
+
+// observeSucceeded counts the message when its outputs are sent, the latency is unknown when the message has no
+// publish time, e.g. a message of a file
+func (runner *Runner) observeSucceeded(msg Message) {
+	if runner.metrics == nil {
+		return
+	}
+	runner.metrics.succeeded.WithLabelValues(sourceTopic(msg)).Inc()
+	if publishTime := msg.PublishTime(); !publishTime.IsZero() {
+		runner.metrics.latency.Observe(time.Since(publishTime).Seconds())
+	}
+}
+
+// observeFailed counts the message when the script fails to process it
+func (runner *Runner) observeFailed(msg Message) {
+	if runner.metrics != nil {
+		runner.metrics.failed.WithLabelValues(sourceTopic(msg)).Inc()
+	}
+}
+
+// observeFiltered counts the message when it's acked without any output
+func (runner *Runner) observeFiltered(msg Message) {
+	if runner.metrics != nil {
+		runner.metrics.filtered.WithLabelValues(sourceTopic(msg)).Inc()
+	}
+}
+
+// observeDeadLettered counts the message when it's sent to the dead letter topic
+func (runner *Runner) observeDeadLettered(msg Message) {
+	if runner.metrics != nil {
+		runner.metrics.deadLettered.WithLabelValues(sourceTopic(msg)).Inc()
+	}
+}
+
+// observeRetried counts the failed message when it's redelivered
+func (runner *Runner) observeRetried(msg Message) {
+	if runner.metrics != nil {
+		runner.metrics.retried.WithLabelValues(sourceTopic(msg)).Inc()
+	}
+}
+
+// observeSent records the size of the output when it's sent
+func (runner *Runner) observeSent(out *OutputMessage) {
+	if runner.metrics != nil {
+		runner.metrics.outputSize.Observe(float64(len(out.Payload)))
+	}
+}
+
+// observeSendFailure counts the message which can't be sent to the topic, an empty topic means the default one
+func (runner *Runner) observeSendFailure(topic string) {
+	if runner.metrics == nil {
+		return
+	}
+	if topic == "" {
+		topic = runner.outputTopic
+	}
+	runner.metrics.sendFailures.WithLabelValues(topic).Inc()
+}
+
+// observeScript counts the script as in flight, and the returned function records how long it runs when it ends
+func (runner *Runner) observeScript() func(duration time.Duration) {
+	if runner.metrics == nil {
+		return func(time.Duration) {}
+	}
+	runner.metrics.inFlight.Inc()
+	return func(duration time.Duration) {
+		runner.metrics.inFlight.Dec()
+		runner.metrics.scriptDuration.Observe(duration.Seconds())
+	}
+}
+
+// observeWorkerRestart counts the restart of a worker process
+func (runner *Runner) observeWorkerRestart() {
+	if runner.metrics != nil {
+		runner.metrics.workerRestarts.Inc()
+	}
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunner_Metrics(t *testing.T) {
+	tests := []struct {
+		name            string
+		script          string
+		sendErr         error
+		expectSucceeded float64
+		expectFailed    float64
+		expectRetried   float64
+		expectFailures  float64
+	}{
+		{
+			name:            "it should count the succeeded messages",
+			script:          "../scripts/exec.sh",
+			expectSucceeded: 2,
+		},
+		{
+			name:          "it should count the failed messages which are retried",
+			script:        "../scripts/exit.sh",
+			expectFailed:  2,
+			expectRetried: 2,
+		},
+		{
+			name:           "it should count the outputs which can't be sent",
+			script:         "../scripts/exec.sh",
+			sendErr:        errors.New("broker is down"),
+			expectFailures: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newMemorySource(
+				&mockMessage{id: "1:1:-1:-1", topic: "in", payload: []byte("a"), publishTime: time.Now()},
+				&mockMessage{id: "1:2:-1:-1", topic: "in", payload: []byte("bc"), publishTime: time.Now()},
+			)
+			sink := NewMemorySink()
+			sink.FailWith(tt.sendErr)
+			runner, err := NewRunnerWith(Config{OutputTopic: "out"}, source, sink)
+			require.NoError(t, err)
+			require.NoError(t, runner.Run(context.Background(), tt.script))
+
+			m := runner.metrics
+			assert.Equal(t, float64(2), testutil.ToFloat64(m.received.WithLabelValues("in")))
+			assert.Equal(t, tt.expectSucceeded, testutil.ToFloat64(m.succeeded.WithLabelValues("in")))
+			assert.Equal(t, tt.expectFailed, testutil.ToFloat64(m.failed.WithLabelValues("in")))
+			assert.Equal(t, tt.expectRetried, testutil.ToFloat64(m.retried.WithLabelValues("in")))
+			assert.Equal(t, tt.expectFailures, testutil.ToFloat64(m.sendFailures.WithLabelValues("out")))
+			assert.Equal(t, float64(0), testutil.ToFloat64(m.inFlight))
+		})
+	}
+}
+
+func TestRunner_MetricsHandler(t *testing.T) {
+	source := newMemorySource(&mockMessage{id: "1:1:-1:-1", topic: "in", payload: []byte("a"), publishTime: time.Now()})
+	runner, err := NewRunnerWith(Config{OutputTopic: "out"}, source, NewMemorySink())
+	require.NoError(t, err)
+	require.NoError(t, runner.Run(context.Background(), "../scripts/exec.sh"))
+
+	recorder := httptest.NewRecorder()
+	runner.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	for _, line := range []string{
+		`bash_runtime_messages_received_total{topic="in"} 1`,
+		`bash_runtime_messages_succeeded_total{topic="in"} 1`,
+		`bash_runtime_script_duration_seconds_count 1`,
+		`bash_runtime_end_to_end_latency_seconds_count 1`,
+		`bash_runtime_payload_size_bytes_sum 1`,
+		`bash_runtime_output_size_bytes_sum 2`,
+	} {
+		assert.True(t, strings.Contains(string(body), line), "missing %s", line)
+	}
+}
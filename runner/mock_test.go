@@ -0,0 +1,165 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"sync"
+	"time"
+)
+
+// mockMessage implements Message with the given fields
+type mockMessage struct {
+	id              string
+	payload         []byte
+	topic           string
+	key             string
+	properties      map[string]string
+	redeliveryCount uint32
+	producerName    string
+	publishTime     time.Time
+	eventTime       time.Time
+}
+
+func (msg *mockMessage) Payload() []byte {
+	return msg.payload
+}
+
+func (msg *mockMessage) Topic() string {
+	return msg.topic
+}
+
+func (msg *mockMessage) Key() string {
+	return msg.key
+}
+
+func (msg *mockMessage) OrderingKey() string {
+	return ""
+}
+
+func (msg *mockMessage) Properties() map[string]string {
+	return msg.properties
+}
+
+func (msg *mockMessage) RedeliveryCount() uint32 {
+	return msg.redeliveryCount
+}
+
+func (msg *mockMessage) ProducerName() string {
+	return msg.producerName
+}
+
+func (msg *mockMessage) PublishTime() time.Time {
+	return msg.publishTime
+}
+
+func (msg *mockMessage) EventTime() time.Time {
+	return msg.eventTime
+}
+
+func (msg *mockMessage) ID() string {
+	return msg.id
+}
+
+// newMemorySource creates an ended memory source with the messages
+func newMemorySource(msgs ...Message) *MemorySource {
+	source := NewMemorySource(len(msgs))
+	source.Add(msgs...)
+	source.End()
+	return source
+}
+
+// mockPulsarMessage implements the pulsar.Message methods used by the pulsar source
+type mockPulsarMessage struct {
+	pulsar.Message
+	payload []byte
+}
+
+func (msg *mockPulsarMessage) Payload() []byte {
+	return msg.payload
+}
+
+func (msg *mockPulsarMessage) ID() pulsar.MessageID {
+	return pulsar.EarliestMessageID()
+}
+
+// mockConsumer is a pulsar consumer which delivers the queued messages and records acks/nacks, Receive fails once
+// all messages are consumed
+type mockConsumer struct {
+	pulsar.Consumer
+	mu         sync.Mutex
+	messages   chan pulsar.Message
+	acked      []pulsar.Message
+	nacked     []pulsar.Message
+	reconsumed []pulsar.Message
+}
+
+func newMockConsumer(messages ...pulsar.Message) *mockConsumer {
+	ch := make(chan pulsar.Message, len(messages))
+	for _, msg := range messages {
+		ch <- msg
+	}
+	close(ch)
+	return &mockConsumer{messages: ch}
+}
+
+func (consumer *mockConsumer) Receive(ctx context.Context) (pulsar.Message, error) {
+	select {
+	case msg, ok := <-consumer.messages:
+		if !ok {
+			return nil, errors.New("consumer closed")
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (consumer *mockConsumer) Ack(msg pulsar.Message) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	consumer.acked = append(consumer.acked, msg)
+}
+
+func (consumer *mockConsumer) Nack(msg pulsar.Message) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	consumer.nacked = append(consumer.nacked, msg)
+}
+
+func (consumer *mockConsumer) ReconsumeLater(msg pulsar.Message, _ time.Duration) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	consumer.reconsumed = append(consumer.reconsumed, msg)
+}
+
+// mockProducer is a pulsar producer which records the sent messages, or fails every send when err is set
+type mockProducer struct {
+	pulsar.Producer
+	mu    sync.Mutex
+	topic string
+	err   error
+	sends int
+	sent  []*pulsar.ProducerMessage
+}
+
+func (producer *mockProducer) Topic() string {
+	return producer.topic
+}
+
+func (producer *mockProducer) Send(_ context.Context, msg *pulsar.ProducerMessage) (pulsar.MessageID, error) {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	producer.sends++
+	if producer.err != nil {
+		return nil, producer.err
+	}
+	producer.sent = append(producer.sent, msg)
+	return pulsar.EarliestMessageID(), nil
+}
+
+func (producer *mockProducer) Flush() error {
+	return nil
+}
+
+func (producer *mockProducer) Close() {}
@@ -0,0 +1,152 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// OutputMode decides how the output messages are read from the script
+type OutputMode string
+
+const (
+	// OutputModeRaw sends the whole stdout as the payload of one output message
+	OutputModeRaw OutputMode = "raw"
+	// OutputModeJSONL reads the output records from stdout, one JSON object per line
+	OutputModeJSONL OutputMode = "jsonl"
+	// OutputModeFD reads the output records from the file descriptor in PULSAR_OUTPUT_FD, one JSON object per line,
+	// the stdout is logged along with the stderr
+	OutputModeFD OutputMode = "fd"
+)
+
+// EnvOutputFD is the file descriptor the script writes the output records to in the fd output mode
+const EnvOutputFD = "PULSAR_OUTPUT_FD"
+
+// outputFD is the first file descriptor after stdin, stdout and stderr, see exec.Cmd.ExtraFiles
+const outputFD = 3
+
+// parseOutputMode parses the output mode name, raw is used when it's empty
+func parseOutputMode(name string) (OutputMode, error) {
+	switch mode := OutputMode(strings.ToLower(name)); mode {
+	case "":
+		return OutputModeRaw, nil
+	case OutputModeRaw, OutputModeJSONL, OutputModeFD:
+		return mode, nil
+	default:
+		return OutputModeRaw, fmt.Errorf("unknown output mode '%s', should be one of raw, jsonl and fd", name)
+	}
+}
+
+// outputRecord is an output message written by the script in the jsonl and fd output modes
+type outputRecord struct {
+	// Topic is the destination topic, the OUT_TOPIC is used when it's empty
+	Topic      string            `json:"topic"`
+	Key        string            `json:"key"`
+	Properties map[string]string `json:"properties"`
+	Payload    *string           `json:"payload"`
+	// PayloadB64 is the base64 encoded payload for binary data, only one of Payload and PayloadB64 can be set
+	PayloadB64 *string `json:"payload_b64"`
+	// Index is the position of the input message in the batch the record belongs to, it's required in batch mode
+	Index *int `json:"index"`
+	// Error fails the input message of Index in batch mode instead of sending the record
+	Error string `json:"error"`
+}
+
+// output is a message to be sent to the topic, an empty topic means the OUT_TOPIC
+type output struct {
+	topic   string
+	message *OutputMessage
+	// index is the index of the record, -1 if it's not set
+	index int
+	err   string
+}
+
+// parseOutput turns the output of the script into the messages to be sent, zero or more messages can be returned
+func parseOutput(data []byte, mode OutputMode) ([]output, error) {
+	if mode == OutputModeRaw || mode == "" {
+		return []output{{message: &OutputMessage{Payload: data}, index: -1}}, nil
+	}
+
+	var outputs []output
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			out, parseErr := parseOutputRecord(line)
+			if parseErr != nil {
+				return nil, fmt.Errorf("%w: line %d, %s", common.ErrInvalidOutput, n, parseErr)
+			}
+			outputs = append(outputs, out)
+		}
+		if err == io.EOF {
+			return outputs, nil
+		}
+	}
+}
+
+func parseOutputRecord(line []byte) (output, error) {
+	var record outputRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return output{}, err
+	}
+	var payload []byte
+	switch {
+	case record.Payload != nil && record.PayloadB64 != nil:
+		return output{}, fmt.Errorf("only one of payload and payload_b64 can be set")
+	case record.PayloadB64 != nil:
+		decoded, err := base64.StdEncoding.DecodeString(*record.PayloadB64)
+		if err != nil {
+			return output{}, fmt.Errorf("invalid payload_b64, %s", err)
+		}
+		payload = decoded
+	case record.Payload != nil:
+		payload = []byte(*record.Payload)
+	}
+	index := -1
+	if record.Index != nil {
+		index = *record.Index
+	}
+	return output{
+		topic: record.Topic,
+		index: index,
+		err:   record.Error,
+		message: &OutputMessage{
+			Payload:    payload,
+			Key:        record.Key,
+			Properties: record.Properties,
+		},
+	}, nil
+}
+
+// attachOutputFD passes a pipe to the command as the output file descriptor, the returned function closes the
+// pipe and returns what the script wrote to it, it should be called after the command exits
+func attachOutputFD(cmd *exec.Cmd) (func() []byte, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.ExtraFiles = []*os.File{writer}
+	cmd.Env = append(cmd.Env, EnvOutputFD+"="+strconv.Itoa(outputFD))
+
+	// read it while the script is running, so that the script is not blocked when the pipe is full
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(&buf, reader)
+		_ = reader.Close()
+	}()
+	return func() []byte {
+		_ = writer.Close()
+		<-done
+		return buf.Bytes()
+	}, nil
+}
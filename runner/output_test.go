@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseOutputMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    OutputMode
+		wantErr bool
+	}{
+		{
+			name:  "it should use raw by default",
+			value: "",
+			want:  OutputModeRaw,
+		},
+		{
+			name:  "it should parse jsonl mode",
+			value: "jsonl",
+			want:  OutputModeJSONL,
+		},
+		{
+			name:  "it should parse fd mode",
+			value: "FD",
+			want:  OutputModeFD,
+		},
+		{
+			name:    "it should fail to parse unknown mode",
+			value:   "xml",
+			want:    OutputModeRaw,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOutputMode(tt.value)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		mode        OutputMode
+		want        []output
+		expectError error
+	}{
+		{
+			name: "it should send the whole output as one message in raw mode",
+			data: "hello\nworld",
+			mode: OutputModeRaw,
+			want: []output{{message: &OutputMessage{Payload: []byte("hello\nworld")}, index: -1}},
+		},
+		{
+			name: "it should parse every line as a message in jsonl mode",
+			data: `{"key": "k1", "properties": {"a": "b"}, "payload": "hello"}` + "\n\n" +
+				`{"topic": "audit", "payload_b64": "d29ybGQ="}`,
+			mode: OutputModeJSONL,
+			want: []output{
+				{message: &OutputMessage{Key: "k1", Properties: map[string]string{"a": "b"}, Payload: []byte("hello")}, index: -1},
+				{topic: "audit", message: &OutputMessage{Payload: []byte("world")}, index: -1},
+			},
+		},
+		{
+			name: "it should parse the index and error of the records in batch mode",
+			data: `{"index": 0, "payload": "hello"}` + "\n" + `{"index": 1, "error": "bad input"}`,
+			mode: OutputModeJSONL,
+			want: []output{
+				{message: &OutputMessage{Payload: []byte("hello")}, index: 0},
+				{message: &OutputMessage{}, index: 1, err: "bad input"},
+			},
+		},
+		{
+			name: "it should return no message when there is no record in jsonl mode",
+			data: "",
+			mode: OutputModeJSONL,
+			want: nil,
+		},
+		{
+			name:        "it should fail when the record is not json",
+			data:        `{"payload": "hello"}` + "\nhello",
+			mode:        OutputModeJSONL,
+			expectError: common.ErrInvalidOutput,
+		},
+		{
+			name:        "it should fail when both payload and payload_b64 are set",
+			data:        `{"payload": "hello", "payload_b64": "d29ybGQ="}`,
+			mode:        OutputModeJSONL,
+			expectError: common.ErrInvalidOutput,
+		},
+		{
+			name:        "it should fail when payload_b64 is not base64 encoded",
+			data:        `{"payload_b64": "hello world"}`,
+			mode:        OutputModeJSONL,
+			expectError: common.ErrInvalidOutput,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOutput([]byte(tt.data), tt.mode)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, true, errors.Is(err, tt.expectError))
+		})
+	}
+}
+
+func TestExecScript_OutputFD(t *testing.T) {
+	stdout, stderr, err := execScript(context.Background(), "../scripts/output-fd.sh", []byte("hello"), nil,
+		execOptions{outputMode: OutputModeFD})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"payload": "hello!"}`, string(stdout))
+	assert.Equal(t, "this line is logged", string(stderr))
+}
+
+func TestRunner_SendOutputs(t *testing.T) {
+	msg := &mockMessage{payload: []byte("hello"), key: "k1"}
+	source := newMemorySource(msg)
+	sink := NewMemorySink()
+	runner := &Runner{
+		source:      source,
+		sink:        sink,
+		logger:      logrus.New(),
+		execOptions: execOptions{outputMode: OutputModeJSONL},
+	}
+
+	err := runner.Run(context.Background(), "../scripts/output-jsonl.sh")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(source.Acked()))
+	assert.Equal(t, []*OutputMessage{
+		{Key: "k1", Properties: map[string]string{"source": "bash"}, Payload: []byte("hello!")},
+	}, sink.Sent(""))
+	assert.Equal(t, []*OutputMessage{{Payload: []byte("hello")}}, sink.Sent("audit"))
+}
@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// defaultReceiverQueueSize is the receiver queue size of the pulsar consumer by default
+const defaultReceiverQueueSize = 1000
+
+// workerPool processes messages with a fixed number of workers, messages with the same key are always handled by
+// the same worker when orderByKey is set, so that they are processed sequentially in the order they're received.
+// Each worker then queues up to queueSize messages, so that a slow key doesn't stop the other workers until its queue
+// is full, the keys hashed to a same worker still wait for each other.
+type workerPool struct {
+	channels   []chan Message
+	orderByKey bool
+	next       int
+	wg         sync.WaitGroup
+}
+
+func newWorkerPool(concurrency int, orderByKey bool, queueSize int, handle func(Message)) *workerPool {
+	return startWorkerPool(concurrency, orderByKey, queueSize, func(ch <-chan Message) {
+		for msg := range ch {
+			handle(msg)
+		}
+	})
+}
+
+// startWorkerPool starts the workers which take messages from their channel by work until the channel is closed
+func startWorkerPool(concurrency int, orderByKey bool, queueSize int, work func(<-chan Message)) *workerPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	pool := &workerPool{
+		orderByKey: orderByKey,
+	}
+	// without key ordering, all workers share a same channel and take whatever message comes next, it's unbuffered
+	// so that no more messages are taken from the consumer when all workers are busy
+	channels, size := 1, 0
+	if orderByKey {
+		channels, size = concurrency, queueSize
+	}
+	for i := 0; i < channels; i++ {
+		pool.channels = append(pool.channels, make(chan Message, size))
+	}
+	for i := 0; i < concurrency; i++ {
+		ch := pool.channels[i%channels]
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			work(ch)
+		}()
+	}
+	return pool
+}
+
+// dispatch blocks until a worker takes or queues the message, it returns false when ctx is done before that
+func (pool *workerPool) dispatch(ctx context.Context, msg Message) bool {
+	select {
+	case pool.channel(msg) <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (pool *workerPool) channel(msg Message) chan Message {
+	if len(pool.channels) == 1 {
+		return pool.channels[0]
+	}
+	key := messageKey(msg)
+	if key == "" {
+		// messages without key have no order to keep
+		pool.next = (pool.next + 1) % len(pool.channels)
+		return pool.channels[pool.next]
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return pool.channels[hash.Sum32()%uint32(len(pool.channels))]
+}
+
+// drain takes back the queued messages which no worker has taken yet
+func (pool *workerPool) drain() []Message {
+	var msgs []Message
+	for _, ch := range pool.channels {
+	queued:
+		for {
+			select {
+			case msg := <-ch:
+				msgs = append(msgs, msg)
+			default:
+				break queued
+			}
+		}
+	}
+	return msgs
+}
+
+// stop waits for the workers to finish the messages they have taken
+func (pool *workerPool) stop() {
+	for _, ch := range pool.channels {
+		close(ch)
+	}
+	pool.wg.Wait()
+}
+
+// messageKey returns the key used to order the message, the ordering key takes precedence over the partition key
+func messageKey(msg Message) string {
+	if key := msg.OrderingKey(); key != "" {
+		return key
+	}
+	return msg.Key()
+}
@@ -0,0 +1,132 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_OrderByKey(t *testing.T) {
+	var mu sync.Mutex
+	processed := map[string][]string{}
+	running, maxRunning := 0, 0
+	pool := newWorkerPool(4, true, 0, func(msg Message) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		processed[msg.Key()] = append(processed[msg.Key()], string(msg.Payload()))
+		mu.Unlock()
+	})
+
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	expected := map[string][]string{}
+	for i := 0; i < 10; i++ {
+		for _, key := range keys {
+			payload := fmt.Sprintf("%s-%d", key, i)
+			expected[key] = append(expected[key], payload)
+			ok := pool.dispatch(context.Background(), &mockMessage{key: key, payload: []byte(payload)})
+			assert.Equal(t, true, ok)
+		}
+	}
+	pool.stop()
+
+	// messages of a same key should be processed in order, while different keys are processed in parallel
+	assert.Equal(t, expected, processed)
+	assert.Greater(t, maxRunning, 1)
+}
+
+func TestWorkerPool_Dispatch(t *testing.T) {
+	release := make(chan struct{})
+	pool := newWorkerPool(1, false, 0, func(msg Message) {
+		<-release
+	})
+
+	ok := pool.dispatch(context.Background(), &mockMessage{payload: []byte("hello")})
+	assert.Equal(t, true, ok)
+
+	// the only worker is busy, dispatch should block until ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	ok = pool.dispatch(ctx, &mockMessage{payload: []byte("world")})
+	assert.Equal(t, false, ok)
+
+	close(release)
+	pool.stop()
+}
+
+func TestWorkerPool_SlowKey(t *testing.T) {
+	release, started := make(chan struct{}), make(chan struct{}, 2)
+	processed := make(chan string, 3)
+	pool := newWorkerPool(2, true, 10, func(msg Message) {
+		if msg.Key() == "slow" {
+			started <- struct{}{}
+			<-release
+			return
+		}
+		processed <- string(msg.Payload())
+	})
+	// find a key handled by the other worker
+	fast := "a"
+	for pool.channel(&mockMessage{key: fast}) == pool.channel(&mockMessage{key: "slow"}) {
+		fast += "a"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// the second slow message is queued behind the first one, without blocking the fast key
+	assert.True(t, pool.dispatch(ctx, &mockMessage{key: "slow", payload: []byte("slow-1")}))
+	assert.True(t, pool.dispatch(ctx, &mockMessage{key: "slow", payload: []byte("slow-2")}))
+	for i := 0; i < 3; i++ {
+		assert.True(t, pool.dispatch(ctx, &mockMessage{key: fast, payload: []byte(fmt.Sprintf("fast-%d", i))}))
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case payload := <-processed:
+			assert.Equal(t, fmt.Sprintf("fast-%d", i), payload)
+		case <-ctx.Done():
+			t.Fatal("the fast key is blocked by the slow one")
+		}
+	}
+
+	<-started
+	queued := pool.drain()
+	assert.Len(t, queued, 1)
+	assert.Equal(t, []byte("slow-2"), queued[0].Payload())
+	close(release)
+	pool.stop()
+}
+
+func TestRunner_RunConcurrently(t *testing.T) {
+	messages := []Message{}
+	for i := 0; i < 4; i++ {
+		messages = append(messages, &mockMessage{payload: []byte("0.5")})
+	}
+	source := newMemorySource(messages...)
+	sink := NewMemorySink()
+	runner := &Runner{
+		source:      source,
+		sink:        sink,
+		logger:      logrus.New(),
+		concurrency: 4,
+	}
+
+	start := time.Now()
+	err := runner.Run(context.Background(), "../scripts/sleep.sh")
+	assert.Equal(t, nil, err)
+	// Run should wait for all the in-flight scripts before returning
+	assert.Less(t, time.Since(start), 1500*time.Millisecond)
+	assert.Equal(t, 4, len(source.Acked()))
+	assert.Equal(t, 4, len(sink.Sent("")))
+}
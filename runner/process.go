@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const defaultKillGrace = 3 * time.Second
+
+// runProcessGroup runs the command in its own process group, and kills the whole group when ctx is done,
+// so that the processes spawned by the script are killed too, and they won't keep the output pipes open.
+func runProcessGroup(ctx context.Context, cmd *exec.Cmd, killGrace time.Duration) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd.Process.Pid, killGrace, done)
+		case <-done:
+		}
+	}()
+	return cmd.Wait()
+}
+
+// killProcessGroup sends SIGTERM to the process group, and then SIGKILL if exited is not closed after the kill grace
+func killProcessGroup(pid int, killGrace time.Duration, exited <-chan struct{}) {
+	if killGrace <= 0 {
+		killGrace = defaultKillGrace
+	}
+	_ = syscall.Kill(-pid, syscall.SIGTERM)
+	timer := time.NewTimer(killGrace)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		_ = syscall.Kill(-pid, syscall.SIGKILL)
+	case <-exited:
+	}
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// systemProperties are set by the pulsar client for the retry letter topic, they're never propagated
+var systemProperties = map[string]bool{
+	pulsar.SysPropertyDelayTime:       true,
+	pulsar.SysPropertyRealTopic:       true,
+	pulsar.SysPropertyRetryTopic:      true,
+	pulsar.SysPropertyReconsumeTimes:  true,
+	pulsar.SysPropertyOriginMessageID: true,
+}
+
+// traceProperties are replaced by the trace context of the publish span, they're never propagated
+var traceProperties = map[string]bool{
+	PropertyTraceParent: true,
+	PropertyTraceState:  true,
+}
+
+// PropagationPolicy decides what is copied from the input message to its output messages,
+// the values set by the script in the output records are never overwritten
+type PropagationPolicy struct {
+	// Key copies the partition key
+	Key bool
+	// Properties lists the properties to copy, "*" copies all of them
+	Properties []string
+	// EventTime copies the event time
+	EventTime bool
+	// Lineage adds the source-topic and source-message-id properties
+	Lineage bool
+}
+
+// apply copies the metadata of the input message to the outputs by the policy
+func (policy PropagationPolicy) apply(msg Message, outputs []output) {
+	for _, out := range outputs {
+		if policy.Key && out.message.Key == "" {
+			out.message.Key = msg.Key()
+		}
+		if policy.EventTime && out.message.EventTime.IsZero() {
+			out.message.EventTime = msg.EventTime()
+		}
+		for key, value := range policy.properties(msg) {
+			if out.message.Properties == nil {
+				out.message.Properties = map[string]string{}
+			}
+			if _, ok := out.message.Properties[key]; !ok {
+				out.message.Properties[key] = value
+			}
+		}
+	}
+}
+
+// properties returns the properties to be added to the output messages
+func (policy PropagationPolicy) properties(msg Message) map[string]string {
+	properties := map[string]string{}
+	for _, name := range policy.Properties {
+		if name == "*" {
+			for key, value := range msg.Properties() {
+				if !systemProperties[key] && !traceProperties[key] {
+					properties[key] = value
+				}
+			}
+		} else if value, ok := msg.Properties()[name]; ok && !systemProperties[name] && !traceProperties[name] {
+			properties[name] = value
+		}
+	}
+	if policy.Lineage {
+		properties[PropertySourceTopic] = sourceTopic(msg)
+		properties[PropertySourceMessageID] = msg.ID()
+	}
+	return properties
+}
@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPropagationPolicy_Apply(t *testing.T) {
+	eventTime := time.Date(2022, 4, 1, 8, 0, 0, 0, time.UTC)
+	msg := &mockMessage{
+		id:    "10:1:-1:-1",
+		topic: "persistent://public/default/in",
+		key:   "customer-1",
+		properties: map[string]string{
+			"trace-id":                       "abc",
+			"tenant":                         "t1",
+			pulsar.SysPropertyReconsumeTimes: "1",
+		},
+		eventTime: eventTime,
+	}
+	tests := []struct {
+		name   string
+		policy PropagationPolicy
+		output *OutputMessage
+		want   *OutputMessage
+	}{
+		{
+			name:   "it should copy nothing by default",
+			policy: PropagationPolicy{},
+			output: &OutputMessage{Payload: []byte("hello")},
+			want:   &OutputMessage{Payload: []byte("hello")},
+		},
+		{
+			name:   "it should copy the key, event time and all the properties except the system ones",
+			policy: PropagationPolicy{Key: true, EventTime: true, Properties: []string{"*"}},
+			output: &OutputMessage{Payload: []byte("hello")},
+			want: &OutputMessage{
+				Payload:    []byte("hello"),
+				Key:        "customer-1",
+				EventTime:  eventTime,
+				Properties: map[string]string{"trace-id": "abc", "tenant": "t1"},
+			},
+		},
+		{
+			name:   "it should only copy the allowed properties and add the lineage properties",
+			policy: PropagationPolicy{Properties: []string{"trace-id", "missing"}, Lineage: true},
+			output: &OutputMessage{Payload: []byte("hello")},
+			want: &OutputMessage{
+				Payload: []byte("hello"),
+				Properties: map[string]string{
+					"trace-id":              "abc",
+					PropertySourceTopic:     "persistent://public/default/in",
+					PropertySourceMessageID: "10:1:-1:-1",
+				},
+			},
+		},
+		{
+			name:   "it should not overwrite the values set by the script",
+			policy: PropagationPolicy{Key: true, Properties: []string{"*"}},
+			output: &OutputMessage{
+				Payload:    []byte("hello"),
+				Key:        "customer-2",
+				Properties: map[string]string{"tenant": "t2"},
+			},
+			want: &OutputMessage{
+				Payload:    []byte("hello"),
+				Key:        "customer-2",
+				Properties: map[string]string{"trace-id": "abc", "tenant": "t2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.apply(msg, []output{{message: tt.output}})
+			assert.Equal(t, tt.want, tt.output)
+		})
+	}
+}
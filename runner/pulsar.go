@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"sync"
+	"time"
+)
+
+// pulsarMessage adapts a pulsar message to Message
+type pulsarMessage struct {
+	pulsar.Message
+}
+
+// ID formats the message id as ledgerId:entryId:partitionIdx:batchIdx
+func (msg pulsarMessage) ID() string {
+	return common.FormatMessageID(msg.Message.ID())
+}
+
+// pulsarSource receives the messages from a pulsar consumer
+type pulsarSource struct {
+	consumer pulsar.Consumer
+}
+
+func newPulsarSource(consumer pulsar.Consumer) *pulsarSource {
+	return &pulsarSource{consumer: consumer}
+}
+
+func (source *pulsarSource) Receive(ctx context.Context) (Message, error) {
+	msg, err := source.consumer.Receive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pulsarMessage{msg}, nil
+}
+
+func (source *pulsarSource) Ack(msg Message) {
+	if msg, ok := msg.(pulsarMessage); ok {
+		source.consumer.Ack(msg.Message)
+	}
+}
+
+func (source *pulsarSource) Nack(msg Message) {
+	if msg, ok := msg.(pulsarMessage); ok {
+		source.consumer.Nack(msg.Message)
+	}
+}
+
+func (source *pulsarSource) ReconsumeLater(msg Message, delay time.Duration) {
+	if msg, ok := msg.(pulsarMessage); ok {
+		source.consumer.ReconsumeLater(msg.Message, delay)
+	}
+}
+
+func (source *pulsarSource) Close() {
+	source.consumer.Close()
+}
+
+// pulsarSink sends the messages with pulsar producers, one producer per topic
+type pulsarSink struct {
+	// producer is the producer of the default output topic
+	producer pulsar.Producer
+	// producers of the topics other than the default one, they're created when they're used for the first time
+	producers      map[string]pulsar.Producer
+	producersLock  sync.Mutex
+	createProducer func(topic string) (pulsar.Producer, error)
+}
+
+func newPulsarSink(client pulsar.Client, topic string) (*pulsarSink, error) {
+	createProducer := func(topic string) (pulsar.Producer, error) {
+		return client.CreateProducer(pulsar.ProducerOptions{
+			Topic: topic,
+		})
+	}
+	producer, err := createProducer(topic)
+	if err != nil {
+		return nil, err
+	}
+	return &pulsarSink{
+		producer:       producer,
+		createProducer: createProducer,
+	}, nil
+}
+
+func (sink *pulsarSink) Send(ctx context.Context, topic string, msg *OutputMessage) error {
+	producer, err := sink.producerFor(topic)
+	if err != nil {
+		return err
+	}
+	_, err = producer.Send(ctx, &pulsar.ProducerMessage{
+		Payload:    msg.Payload,
+		Key:        msg.Key,
+		Properties: msg.Properties,
+		EventTime:  msg.EventTime,
+	})
+	return err
+}
+
+// producerFor returns the producer of the topic, an empty topic means the default output topic
+func (sink *pulsarSink) producerFor(topic string) (pulsar.Producer, error) {
+	if topic == "" || topic == sink.producer.Topic() {
+		return sink.producer, nil
+	}
+	sink.producersLock.Lock()
+	defer sink.producersLock.Unlock()
+	if producer, ok := sink.producers[topic]; ok {
+		return producer, nil
+	}
+	producer, err := sink.createProducer(topic)
+	if err != nil {
+		return nil, err
+	}
+	if sink.producers == nil {
+		sink.producers = map[string]pulsar.Producer{}
+	}
+	sink.producers[topic] = producer
+	return producer, nil
+}
+
+// Flush flushes all the producers, and returns the first error
+func (sink *pulsarSink) Flush() error {
+	err := sink.producer.Flush()
+	sink.producersLock.Lock()
+	defer sink.producersLock.Unlock()
+	for _, producer := range sink.producers {
+		if flushErr := producer.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+func (sink *pulsarSink) Close() {
+	sink.producer.Close()
+	sink.producersLock.Lock()
+	defer sink.producersLock.Unlock()
+	for _, producer := range sink.producers {
+		producer.Close()
+	}
+}
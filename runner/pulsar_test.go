@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPulsarSource(t *testing.T) {
+	first := &mockPulsarMessage{payload: []byte("first")}
+	second := &mockPulsarMessage{payload: []byte("second")}
+	third := &mockPulsarMessage{payload: []byte("third")}
+	consumer := newMockConsumer(first, second, third)
+	source := newPulsarSource(consumer)
+
+	var msgs []Message
+	for i := 0; i < 3; i++ {
+		msg, err := source.Receive(context.Background())
+		assert.Equal(t, nil, err)
+		msgs = append(msgs, msg)
+	}
+	assert.Equal(t, "first", string(msgs[0].Payload()))
+	assert.Equal(t, "-1:-1:-1:-1", msgs[0].ID())
+	_, err := source.Receive(context.Background())
+	assert.Equal(t, true, err != nil)
+
+	// the messages are unwrapped before they're handed back to the consumer
+	source.Ack(msgs[0])
+	source.Nack(msgs[1])
+	source.ReconsumeLater(msgs[2], time.Second)
+	assert.Equal(t, []pulsar.Message{first}, consumer.acked)
+	assert.Equal(t, []pulsar.Message{second}, consumer.nacked)
+	assert.Equal(t, []pulsar.Message{third}, consumer.reconsumed)
+}
+
+func TestPulsarSink_Send(t *testing.T) {
+	producer := &mockProducer{topic: "out"}
+	auditProducer := &mockProducer{topic: "audit"}
+	created := 0
+	sink := &pulsarSink{
+		producer: producer,
+		createProducer: func(topic string) (pulsar.Producer, error) {
+			created++
+			assert.Equal(t, "audit", topic)
+			return auditProducer, nil
+		},
+	}
+
+	assert.Nil(t, sink.Send(context.Background(), "", &OutputMessage{Payload: []byte("a"), Key: "k1"}))
+	assert.Nil(t, sink.Send(context.Background(), "out", &OutputMessage{Payload: []byte("b")}))
+	assert.Nil(t, sink.Send(context.Background(), "audit", &OutputMessage{Payload: []byte("c")}))
+	assert.Nil(t, sink.Send(context.Background(), "audit", &OutputMessage{Payload: []byte("d")}))
+
+	// the producer of the other topic is created once when it's used for the first time
+	assert.Equal(t, 1, created)
+	assert.Equal(t, 2, len(producer.sent))
+	assert.Equal(t, "k1", producer.sent[0].Key)
+	assert.Equal(t, 2, len(auditProducer.sent))
+	assert.Equal(t, "c", string(auditProducer.sent[0].Payload))
+	assert.Nil(t, sink.Flush())
+}
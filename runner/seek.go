@@ -0,0 +1,181 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// SeekPosition is where the subscription is moved to, only one of MessageID and Time can be set
+type SeekPosition struct {
+	// MessageID is formatted as ledgerId:entryId:partitionIdx:batchIdx, the subscription restarts from the message
+	MessageID string
+	// Time restarts the subscription from the first message published at or after it
+	Time time.Time
+}
+
+func (position SeekPosition) String() string {
+	if position.MessageID != "" {
+		return "message " + position.MessageID
+	}
+	return position.Time.UTC().Format(time.RFC3339)
+}
+
+func (position SeekPosition) validate(config Config) error {
+	switch {
+	case config.TopicsPattern != "":
+		return errors.New("seek is not supported with the topics pattern")
+	case position.MessageID == "" && position.Time.IsZero():
+		return errors.New("either the message id or the time is required to seek")
+	case position.MessageID != "" && !position.Time.IsZero():
+		return errors.New("only one of the message id and the time can be set to seek")
+	case position.MessageID != "" && strings.Contains(config.InputTopics, ","):
+		return errors.New("seek to a message id is only supported with a single input topic")
+	}
+	return nil
+}
+
+// partitionSeeker seeks or counts the messages of a single partition, or of a non-partitioned topic
+type partitionSeeker interface {
+	seek(topic string, id pulsar.MessageID, t time.Time) error
+	count(topic string, id pulsar.MessageID, t time.Time) (int, error)
+}
+
+// Seek moves the subscription of the input topics to the position before Run, so that the messages after it are
+// processed again. When dryRun is true, the subscription is not moved, and it returns how many messages there are
+// from the position to the end of the topics instead.
+func Seek(config Config, position SeekPosition, dryRun bool) (int, error) {
+	if err := position.validate(config); err != nil {
+		return 0, err
+	}
+	subscriptionType, err := parseSubscriptionType(config.SubscriptionType)
+	if err != nil {
+		return 0, err
+	}
+	client, err := newClient(config)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	seeker := &clientSeeker{
+		client:           client,
+		subscription:     config.Subscription,
+		subscriptionType: subscriptionType,
+	}
+	total := 0
+	for _, topic := range strings.Split(config.InputTopics, ",") {
+		partitions, err := client.TopicPartitions(topic)
+		if err != nil {
+			return total, fmt.Errorf("failed to get the partitions of %s, %s", topic, err)
+		}
+		count, err := seekPartitions(seeker, partitions, position, dryRun)
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// seekPartitions seeks every partition of a topic, pulsar doesn't allow to seek a partitioned topic as a whole.
+// A message id only belongs to the partition in it.
+func seekPartitions(seeker partitionSeeker, partitions []string, position SeekPosition, dryRun bool) (int, error) {
+	var id pulsar.MessageID
+	partition := -1
+	if position.MessageID != "" {
+		parsed, err := common.ParseMessageID(position.MessageID)
+		if err != nil {
+			return 0, err
+		}
+		if len(partitions) > 1 {
+			partition = int(parsed.PartitionIdx())
+			if partition < 0 || partition >= len(partitions) {
+				return 0, fmt.Errorf("the partition of the message id %s is out of the %d partitions",
+					position.MessageID, len(partitions))
+			}
+		}
+		// the consumer of a single partition only accepts the ids of its first partition
+		if id, err = common.NewMessageID(parsed.LedgerID(), parsed.EntryID(), 0, parsed.BatchIdx()); err != nil {
+			return 0, err
+		}
+	}
+
+	total := 0
+	for i, topic := range partitions {
+		if partition >= 0 && i != partition {
+			continue
+		}
+		if !dryRun {
+			if err := seeker.seek(topic, id, position.Time); err != nil {
+				return total, fmt.Errorf("failed to seek %s, %s", topic, err)
+			}
+			logrus.Infof("subscription of %s is moved to %s", topic, position)
+			continue
+		}
+		count, err := seeker.count(topic, id, position.Time)
+		total += count
+		if err != nil {
+			return total, fmt.Errorf("failed to count the messages of %s, %s", topic, err)
+		}
+		logrus.Infof("%d messages of %s would be replayed", count, topic)
+	}
+	return total, nil
+}
+
+// clientSeeker seeks with the consumers and counts with the readers of pulsar
+type clientSeeker struct {
+	client           pulsar.Client
+	subscription     string
+	subscriptionType pulsar.SubscriptionType
+}
+
+func (seeker *clientSeeker) seek(topic string, id pulsar.MessageID, t time.Time) error {
+	consumer, err := seeker.client.Subscribe(pulsar.ConsumerOptions{
+		Topic:            topic,
+		SubscriptionName: seeker.subscription,
+		Type:             seeker.subscriptionType,
+	})
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+	if id != nil {
+		return consumer.Seek(id)
+	}
+	return consumer.SeekByTime(t)
+}
+
+func (seeker *clientSeeker) count(topic string, id pulsar.MessageID, t time.Time) (int, error) {
+	startID := id
+	if startID == nil {
+		startID = pulsar.EarliestMessageID()
+	}
+	reader, err := seeker.client.CreateReader(pulsar.ReaderOptions{
+		Topic:                   topic,
+		StartMessageID:          startID,
+		StartMessageIDInclusive: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	if id == nil {
+		if err = reader.SeekByTime(t); err != nil {
+			return 0, err
+		}
+	}
+	count := 0
+	for reader.HasNext() {
+		if _, err = reader.Next(context.Background()); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// recordingSeeker records the seeks, and has the given number of messages in every partition
+type recordingSeeker struct {
+	messages int
+	seeks    []string
+	counts   []string
+}
+
+func (seeker *recordingSeeker) seek(topic string, id pulsar.MessageID, t time.Time) error {
+	seeker.seeks = append(seeker.seeks, topic+"@"+position(id, t))
+	return nil
+}
+
+func (seeker *recordingSeeker) count(topic string, id pulsar.MessageID, t time.Time) (int, error) {
+	seeker.counts = append(seeker.counts, topic+"@"+position(id, t))
+	return seeker.messages, nil
+}
+
+func position(id pulsar.MessageID, t time.Time) string {
+	if id != nil {
+		return common.FormatMessageID(id)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func TestSeekPartitions(t *testing.T) {
+	yesterday := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+	partitions := []string{"in-partition-0", "in-partition-1", "in-partition-2"}
+	allPartitions := []string{"in-partition-0@2022-04-01T00:00:00Z", "in-partition-1@2022-04-01T00:00:00Z",
+		"in-partition-2@2022-04-01T00:00:00Z"}
+	tests := []struct {
+		name         string
+		partitions   []string
+		position     SeekPosition
+		dryRun       bool
+		expectSeeks  []string
+		expectCounts []string
+		expectTotal  int
+		expectError  string
+	}{
+		{
+			name:        "it should seek every partition to the time",
+			partitions:  partitions,
+			position:    SeekPosition{Time: yesterday},
+			expectSeeks: allPartitions,
+		},
+		{
+			name:         "it should count the messages of every partition without seeking in dry run",
+			partitions:   partitions,
+			position:     SeekPosition{Time: yesterday},
+			dryRun:       true,
+			expectCounts: allPartitions,
+			expectTotal:  15,
+		},
+		{
+			name:        "it should only seek the partition of the message id",
+			partitions:  partitions,
+			position:    SeekPosition{MessageID: "12:34:1:-1"},
+			expectSeeks: []string{"in-partition-1@12:34:0:-1"},
+		},
+		{
+			name:        "it should seek the non-partitioned topic to the message id",
+			partitions:  []string{"in"},
+			position:    SeekPosition{MessageID: "12:34:-1:-1"},
+			expectSeeks: []string{"in@12:34:0:-1"},
+		},
+		{
+			name:        "it should reject the message id of an unknown partition",
+			partitions:  partitions,
+			position:    SeekPosition{MessageID: "12:34:3:-1"},
+			expectError: "the partition of the message id 12:34:3:-1 is out of the 3 partitions",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seeker := &recordingSeeker{messages: 5}
+			total, err := seekPartitions(seeker, tt.partitions, tt.position, tt.dryRun)
+			if tt.expectError != "" {
+				assert.EqualError(t, err, tt.expectError)
+				return
+			}
+			assert.Equal(t, nil, err)
+			assert.Equal(t, tt.expectSeeks, seeker.seeks)
+			assert.Equal(t, tt.expectCounts, seeker.counts)
+			assert.Equal(t, tt.expectTotal, total)
+		})
+	}
+}
+
+func TestSeekPosition_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		position    SeekPosition
+		expectError bool
+	}{
+		{
+			name:     "it should seek multiple topics to the time",
+			config:   Config{InputTopics: "in-1,in-2"},
+			position: SeekPosition{Time: time.Now()},
+		},
+		{
+			name:        "it should reject the message id with multiple topics",
+			config:      Config{InputTopics: "in-1,in-2"},
+			position:    SeekPosition{MessageID: "1:2:-1:-1"},
+			expectError: true,
+		},
+		{
+			name:        "it should reject both the message id and the time",
+			config:      Config{InputTopics: "in"},
+			position:    SeekPosition{MessageID: "1:2:-1:-1", Time: time.Now()},
+			expectError: true,
+		},
+		{
+			name:        "it should reject the topics pattern",
+			config:      Config{TopicsPattern: "persistent://public/default/in-.*"},
+			position:    SeekPosition{Time: time.Now()},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectError, tt.position.validate(tt.config) != nil)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Message is a message received from a Source
+type Message interface {
+	// ID identifies the message in its source
+	ID() string
+	Payload() []byte
+	// Topic is where the message comes from
+	Topic() string
+	Key() string
+	// OrderingKey takes precedence over Key when ordering the messages
+	OrderingKey() string
+	Properties() map[string]string
+	// EventTime is zero when it's not set
+	EventTime() time.Time
+	PublishTime() time.Time
+	// RedeliveryCount is how many times the message has been redelivered after failures
+	RedeliveryCount() uint32
+	ProducerName() string
+}
+
+// Source delivers the messages to the runner, and is told how each of them is handled
+type Source interface {
+	// Receive blocks until a message is available, it returns an error when ctx is done or the source is closed
+	Receive(ctx context.Context) (Message, error)
+	// Ack tells the message has been processed
+	Ack(msg Message)
+	// Nack asks to redeliver the message later
+	Nack(msg Message)
+	// ReconsumeLater asks to redeliver the message through the retry letter topic after the delay
+	ReconsumeLater(msg Message, delay time.Duration)
+	Close()
+}
+
+// OutputMessage is a message sent to a Sink
+type OutputMessage struct {
+	Payload    []byte
+	Key        string
+	Properties map[string]string
+	// EventTime is not sent when it's zero
+	EventTime time.Time
+}
+
+// Sink sends the output messages to their topics
+type Sink interface {
+	// Send sends the message to the topic, an empty topic means the default output topic of the sink
+	Send(ctx context.Context, topic string, msg *OutputMessage) error
+	// Flush sends the pending messages
+	Flush() error
+	Close()
+}
+
+// Record is the content of a message which doesn't come from a broker, it's delivered by the file and memory sources
+type Record struct {
+	ID         string
+	Topic      string
+	Key        string
+	Properties map[string]string
+	Payload    []byte
+	EventTime  time.Time
+}
+
+// NewMessage creates a message from the record, it's published at the time it's created
+func NewMessage(record Record) Message {
+	return &localMessage{record: record, publishTime: time.Now()}
+}
+
+// localMessage is a message created from a Record
+type localMessage struct {
+	record      Record
+	publishTime time.Time
+}
+
+func (msg *localMessage) ID() string {
+	return msg.record.ID
+}
+
+func (msg *localMessage) Payload() []byte {
+	return msg.record.Payload
+}
+
+func (msg *localMessage) Topic() string {
+	return msg.record.Topic
+}
+
+func (msg *localMessage) Key() string {
+	return msg.record.Key
+}
+
+func (msg *localMessage) OrderingKey() string {
+	return ""
+}
+
+func (msg *localMessage) Properties() map[string]string {
+	return msg.record.Properties
+}
+
+func (msg *localMessage) EventTime() time.Time {
+	return msg.record.EventTime
+}
+
+func (msg *localMessage) PublishTime() time.Time {
+	return msg.publishTime
+}
+
+func (msg *localMessage) RedeliveryCount() uint32 {
+	return 0
+}
+
+func (msg *localMessage) ProducerName() string {
+	return ""
+}
+
+// ackRecorder records how the messages are handled for the sources which can't redeliver them
+type ackRecorder struct {
+	mu         sync.Mutex
+	acked      []Message
+	nacked     []Message
+	reconsumed []Message
+}
+
+func (recorder *ackRecorder) Ack(msg Message) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.acked = append(recorder.acked, msg)
+}
+
+func (recorder *ackRecorder) Nack(msg Message) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.nacked = append(recorder.nacked, msg)
+}
+
+func (recorder *ackRecorder) ReconsumeLater(msg Message, _ time.Duration) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.reconsumed = append(recorder.reconsumed, msg)
+}
+
+// Acked returns the acked messages in the order they're acked
+func (recorder *ackRecorder) Acked() []Message {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return append([]Message(nil), recorder.acked...)
+}
+
+// Nacked returns the nacked messages in the order they're nacked
+func (recorder *ackRecorder) Nacked() []Message {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return append([]Message(nil), recorder.nacked...)
+}
+
+// Reconsumed returns the messages asked to be redelivered through the retry letter topic
+func (recorder *ackRecorder) Reconsumed() []Message {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return append([]Message(nil), recorder.reconsumed...)
+}
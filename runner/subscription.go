@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"regexp"
+	"strings"
+)
+
+// parseSubscriptionType parses the subscription type name, Shared is used when it's empty
+func parseSubscriptionType(name string) (pulsar.SubscriptionType, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "-", "_")) {
+	case "exclusive":
+		return pulsar.Exclusive, nil
+	case "failover":
+		return pulsar.Failover, nil
+	case "", "shared":
+		return pulsar.Shared, nil
+	case "key_shared", "keyshared":
+		return pulsar.KeyShared, nil
+	default:
+		return pulsar.Shared, fmt.Errorf("unknown subscription type '%s', "+
+			"should be one of Exclusive, Failover, Shared and Key_Shared", name)
+	}
+}
+
+// parseInitialPosition parses where a new subscription starts, latest is used when it's empty
+func parseInitialPosition(name string) (pulsar.SubscriptionInitialPosition, error) {
+	switch strings.ToLower(name) {
+	case "", "latest":
+		return pulsar.SubscriptionPositionLatest, nil
+	case "earliest":
+		return pulsar.SubscriptionPositionEarliest, nil
+	default:
+		return pulsar.SubscriptionPositionLatest, fmt.Errorf("unknown initial position '%s', "+
+			"should be one of earliest and latest", name)
+	}
+}
+
+// consumerOptions subscribes to the InputTopics, or to the topics matching the TopicsPattern when it's set
+func consumerOptions(config Config, subscriptionType pulsar.SubscriptionType) (pulsar.ConsumerOptions, error) {
+	options := pulsar.ConsumerOptions{
+		SubscriptionName:    config.Subscription,
+		Type:                subscriptionType,
+		NackRedeliveryDelay: config.NackRedeliveryDelay,
+		ReceiverQueueSize:   config.ReceiverQueueSize,
+	}
+	initialPosition, err := parseInitialPosition(config.InitialPosition)
+	if err != nil {
+		return options, err
+	}
+	options.SubscriptionInitialPosition = initialPosition
+	if config.TopicsPattern != "" {
+		if _, err := regexp.Compile(config.TopicsPattern); err != nil {
+			return options, fmt.Errorf("invalid topics pattern, %s", err)
+		}
+		// pulsar finds the namespace of the retry letter topic from the input topics, which is unknown for a pattern
+		if config.DeadLetterPolicy != nil && config.DeadLetterPolicy.RetryLetterTopic != "" {
+			return options, errors.New("retry letter topic can't be used with the topics pattern")
+		}
+		options.TopicsPattern = config.TopicsPattern
+		options.AutoDiscoveryPeriod = config.TopicsDiscoveryPeriod
+	} else {
+		options.Topics = strings.Split(config.InputTopics, ",")
+	}
+	if config.DeadLetterPolicy != nil {
+		config.DeadLetterPolicy.applyTo(&options)
+	}
+	return options, nil
+}
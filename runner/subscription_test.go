@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseSubscriptionType(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    pulsar.SubscriptionType
+		wantErr bool
+	}{
+		{
+			name:  "it should use shared subscription by default",
+			value: "",
+			want:  pulsar.Shared,
+		},
+		{
+			name:  "it should parse exclusive subscription",
+			value: "Exclusive",
+			want:  pulsar.Exclusive,
+		},
+		{
+			name:  "it should parse failover subscription",
+			value: "failover",
+			want:  pulsar.Failover,
+		},
+		{
+			name:  "it should parse key shared subscription",
+			value: "Key_Shared",
+			want:  pulsar.KeyShared,
+		},
+		{
+			name:  "it should parse key shared subscription with dash",
+			value: "key-shared",
+			want:  pulsar.KeyShared,
+		},
+		{
+			name:    "it should fail to parse unknown subscription",
+			value:   "broadcast",
+			want:    pulsar.Shared,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSubscriptionType(tt.value)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestParseInitialPosition(t *testing.T) {
+	position, err := parseInitialPosition("")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, pulsar.SubscriptionPositionLatest, position)
+	position, err = parseInitialPosition("Earliest")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, pulsar.SubscriptionPositionEarliest, position)
+	_, err = parseInitialPosition("beginning")
+	assert.NotNil(t, err)
+}
+
+func TestConsumerOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		check       func(t *testing.T, options pulsar.ConsumerOptions)
+		expectError string
+	}{
+		{
+			name:   "it should subscribe to the input topics",
+			config: Config{InputTopics: "in-1,in-2", Subscription: "sub", InitialPosition: "earliest"},
+			check: func(t *testing.T, options pulsar.ConsumerOptions) {
+				assert.Equal(t, []string{"in-1", "in-2"}, options.Topics)
+				assert.Equal(t, pulsar.SubscriptionPositionEarliest, options.SubscriptionInitialPosition)
+				assert.Equal(t, "", options.TopicsPattern)
+				assert.Equal(t, "sub", options.SubscriptionName)
+			},
+		},
+		{
+			name: "it should subscribe to the topics pattern instead of the input topics",
+			config: Config{
+				InputTopics:           "in",
+				TopicsPattern:         "persistent://public/default/orders-.*",
+				TopicsDiscoveryPeriod: 10 * time.Second,
+				DeadLetterPolicy:      &DeadLetterPolicy{DeadLetterTopic: "dlq"},
+			},
+			check: func(t *testing.T, options pulsar.ConsumerOptions) {
+				assert.Nil(t, options.Topics)
+				assert.Equal(t, "persistent://public/default/orders-.*", options.TopicsPattern)
+				assert.Equal(t, 10*time.Second, options.AutoDiscoveryPeriod)
+			},
+		},
+		{
+			name: "it should leave the dead letter topic to the runner",
+			config: Config{
+				InputTopics:      "in",
+				DeadLetterPolicy: &DeadLetterPolicy{MaxRedeliveries: 3, DeadLetterTopic: "dlq", RetryLetterTopic: "retry"},
+			},
+			check: func(t *testing.T, options pulsar.ConsumerOptions) {
+				assert.True(t, options.RetryEnable)
+				assert.Equal(t, "retry", options.DLQ.RetryLetterTopic)
+				assert.Equal(t, "", options.DLQ.DeadLetterTopic)
+				assert.Equal(t, uint32(math.MaxUint32), options.DLQ.MaxDeliveries)
+			},
+		},
+		{
+			name:        "it should reject the invalid topics pattern",
+			config:      Config{TopicsPattern: "persistent://public/default/orders-(.*"},
+			expectError: "invalid topics pattern",
+		},
+		{
+			name: "it should reject the retry letter topic with the topics pattern",
+			config: Config{
+				TopicsPattern:    "persistent://public/default/orders-.*",
+				DeadLetterPolicy: &DeadLetterPolicy{DeadLetterTopic: "dlq", RetryLetterTopic: "retry"},
+			},
+			expectError: "retry letter topic can't be used with the topics pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := consumerOptions(tt.config, pulsar.Shared)
+			if tt.expectError != "" {
+				assert.Contains(t, err.Error(), tt.expectError)
+				return
+			}
+			assert.Equal(t, nil, err)
+			tt.check(t, options)
+		})
+	}
+}
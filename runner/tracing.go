@@ -0,0 +1,254 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// environments passing the W3C trace context of the script span, so that the script can propagate it to its own calls
+const (
+	EnvTraceParent = "TRACEPARENT"
+	EnvTraceState  = "TRACESTATE"
+)
+
+// the properties of the W3C trace context in the messages
+const (
+	PropertyTraceParent = "traceparent"
+	PropertyTraceState  = "tracestate"
+)
+
+const (
+	tracerName         = "bash-runtime/runner"
+	defaultServiceName = "bash-runtime"
+	otlpTracesPath     = "/v1/traces"
+)
+
+var tracePropagator = propagation.TraceContext{}
+
+// TracingConfig decides where the spans are exported
+type TracingConfig struct {
+	// Exporter is one of none, otlp, stdout and file, tracing is disabled when it's empty or none
+	Exporter string
+	// OTLPEndpoint is the url of the OTLP/HTTP receiver like http://localhost:4318, it's optional and overrides the
+	// endpoint of the OTEL_EXPORTER_OTLP_* environments, which also set the headers, TLS, compression and timeout
+	OTLPEndpoint string
+	// File is the file the spans are appended to as JSON by the file exporter
+	File string
+}
+
+// SetupTracing installs the global tracer provider with the exporter, the returned function flushes the spans and
+// stops it. The service name is bash-runtime unless OTEL_SERVICE_NAME is set.
+func SetupTracing(config TracingConfig) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	closeFile := func() error { return nil }
+	switch strings.ToLower(config.Exporter) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		options, err := otlpOptions(config.OTLPEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		if exporter, err = otlptracehttp.New(context.Background(), options...); err != nil {
+			return nil, err
+		}
+	case "stdout":
+		var err error
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout)); err != nil {
+			return nil, err
+		}
+	case "file":
+		if config.File == "" {
+			return nil, errors.New("the file is required by the file exporter")
+		}
+		file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file, %s", err)
+		}
+		closeFile = file.Close
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(file)); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown trace exporter '%s', should be one of none, otlp, stdout and file", config.Exporter)
+	}
+
+	serviceName := resource.NewSchemaless(attribute.String("service.name", defaultServiceName))
+	res, err := resource.Merge(serviceName, resource.Environment())
+	if err != nil {
+		_ = closeFile()
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeFile(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// otlpOptions returns the options of the OTLP/HTTP exporter sending the spans to the endpoint, the spans are sent to
+// /v1/traces under the path of the endpoint. No option is returned when the endpoint is empty, so that the exporter
+// follows the OTEL_EXPORTER_OTLP_* environments.
+func otlpOptions(endpoint string) ([]otlptracehttp.Option, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid otlp endpoint '%s', should be like http://localhost:4318", endpoint)
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if path := strings.TrimRight(u.Path, "/"); path != "" {
+		if !strings.HasSuffix(path, otlpTracesPath) {
+			path += otlpTracesPath
+		}
+		options = append(options, otlptracehttp.WithURLPath(path))
+	}
+	return options, nil
+}
+
+// tracer returns the tracer of the global provider, it's looked up every time so that it follows the provider
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// tracingSource starts a receive span for every message, the span is the child of the traceparent property of the
+// message, and it ends when the message is acked or nacked so that the script and publish spans are its children
+type tracingSource struct {
+	Source
+	spans sync.Map
+}
+
+func (source *tracingSource) Receive(ctx context.Context) (Message, error) {
+	msg, err := source.Source.Receive(ctx)
+	if err != nil {
+		return msg, err
+	}
+	parent := tracePropagator.Extract(context.Background(), propagation.MapCarrier(msg.Properties()))
+	_, span := tracer().Start(parent, "receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "pulsar"),
+			attribute.String("messaging.source.name", sourceTopic(msg)),
+			attribute.String("messaging.message.id", msg.ID()),
+		))
+	source.spans.Store(msg, span)
+	return msg, nil
+}
+
+func (source *tracingSource) Ack(msg Message) {
+	source.Source.Ack(msg)
+	source.end(msg, "")
+}
+
+func (source *tracingSource) Nack(msg Message) {
+	source.Source.Nack(msg)
+	source.end(msg, "nacked")
+}
+
+func (source *tracingSource) ReconsumeLater(msg Message, delay time.Duration) {
+	source.Source.ReconsumeLater(msg, delay)
+	source.end(msg, "sent to the retry letter topic")
+}
+
+// end ends the receive span of the message, it's an error when the message is not acked
+func (source *tracingSource) end(msg Message, failure string) {
+	value, ok := source.spans.LoadAndDelete(msg)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	if failure != "" {
+		span.SetStatus(codes.Error, failure)
+	}
+	span.End()
+}
+
+// context returns the context of the receive span of the message
+func (source *tracingSource) context(msg Message) context.Context {
+	if source == nil {
+		return context.Background()
+	}
+	if value, ok := source.spans.Load(msg); ok {
+		return trace.ContextWithSpan(context.Background(), value.(trace.Span))
+	}
+	return context.Background()
+}
+
+// startSpan starts a span for the messages, it's the child of the receive span of a single message, and links to the
+// receive spans of the messages of a batch or a window
+func (runner *Runner) startSpan(name string, msgs []Message, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parent := context.Background()
+	if len(msgs) == 1 {
+		parent = runner.traces.context(msgs[0])
+	} else {
+		for _, msg := range msgs {
+			opts = append(opts, trace.WithLinks(trace.LinkFromContext(runner.traces.context(msg))))
+		}
+	}
+	return tracer().Start(parent, name, opts...)
+}
+
+// endSpan ends the span with the error status when err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceEnv returns the environments of the trace context of ctx
+func traceEnv(ctx context.Context) []string {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	var env []string
+	if traceParent, ok := carrier[PropertyTraceParent]; ok {
+		env = append(env, EnvTraceParent+"="+traceParent)
+	}
+	if traceState, ok := carrier[PropertyTraceState]; ok {
+		env = append(env, EnvTraceState+"="+traceState)
+	}
+	return env
+}
+
+// injectTrace adds the trace context of ctx to the properties of the output, unless the script sets its own
+func injectTrace(ctx context.Context, msg *OutputMessage) {
+	if _, ok := msg.Properties[PropertyTraceParent]; ok {
+		return
+	}
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+	if msg.Properties == nil {
+		msg.Properties = map[string]string{}
+	}
+	for key, value := range carrier {
+		msg.Properties[key] = value
+	}
+}
@@ -0,0 +1,225 @@
+package runner
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// recordSpans makes the runners record their spans until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+	return recorder
+}
+
+// spansByName returns the ended spans by their names
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestRunner_Tracing(t *testing.T) {
+	tests := []struct {
+		name             string
+		properties       map[string]string
+		expectTraceID    string
+		expectRemoteSpan string
+	}{
+		{
+			name:             "it should continue the trace of the traceparent property",
+			properties:       map[string]string{PropertyTraceParent: testTraceParent},
+			expectTraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+			expectRemoteSpan: "00f067aa0ba902b7",
+		},
+		{
+			name: "it should start a new trace when there is no traceparent property",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := recordSpans(t)
+			source := newMemorySource(&mockMessage{id: "1:1:-1:-1", topic: "in", payload: []byte("a"), properties: tt.properties})
+			sink := NewMemorySink()
+			// the traceparent is not copied from the input, the one of the publish span is used
+			runner, err := NewRunnerWith(Config{Propagation: PropagationPolicy{Properties: []string{"*"}}}, source, sink)
+			require.NoError(t, err)
+			require.NoError(t, runner.Run(context.Background(), "../scripts/traceparent.sh"))
+
+			spans := spansByName(recorder)
+			require.Len(t, spans, 3)
+			receive, script, publish := spans["receive"], spans["script"], spans["publish"]
+			traceID := receive.SpanContext().TraceID().String()
+			if tt.expectTraceID != "" {
+				assert.Equal(t, tt.expectTraceID, traceID)
+				assert.Equal(t, tt.expectRemoteSpan, receive.Parent().SpanID().String())
+			} else {
+				assert.False(t, receive.Parent().IsValid())
+			}
+			assert.Equal(t, receive.SpanContext().SpanID(), script.Parent().SpanID())
+			assert.Equal(t, receive.SpanContext().SpanID(), publish.Parent().SpanID())
+			assert.Equal(t, int64(0), spanAttribute(script, "process.exit.code").AsInt64())
+			assert.True(t, spanAttribute(script, "script.duration_ms").AsFloat64() > 0)
+
+			sent := sink.Sent("")
+			require.Len(t, sent, 1)
+			// the script gets the context of its own span, and the output carries the one of the publish span
+			assert.Equal(t, "00-"+traceID+"-"+script.SpanContext().SpanID().String()+"-01", string(sent[0].Payload))
+			assert.Equal(t, "00-"+traceID+"-"+publish.SpanContext().SpanID().String()+"-01",
+				sent[0].Properties[PropertyTraceParent])
+		})
+	}
+}
+
+func TestRunner_TracingFailure(t *testing.T) {
+	recorder := recordSpans(t)
+	source := newMemorySource(&mockMessage{id: "1:1:-1:-1", topic: "in", payload: []byte("a")})
+	runner, err := NewRunnerWith(Config{}, source, NewMemorySink())
+	require.NoError(t, err)
+	require.NoError(t, runner.Run(context.Background(), "../scripts/exit.sh"))
+
+	spans := spansByName(recorder)
+	require.Len(t, spans, 2)
+	assert.Equal(t, int64(1), spanAttribute(spans["script"], "process.exit.code").AsInt64())
+	assert.Equal(t, codes.Error, spans["script"].Status().Code)
+	assert.Equal(t, sdktrace.Status{Code: codes.Error, Description: "nacked"}, spans["receive"].Status())
+}
+
+func TestRunner_TracingBatch(t *testing.T) {
+	requireJQ(t)
+	recorder := recordSpans(t)
+	source := newMemorySource(
+		&mockMessage{id: "1:1:-1:-1", topic: "in", payload: []byte("a"), properties: map[string]string{PropertyTraceParent: testTraceParent}},
+		&mockMessage{id: "1:2:-1:-1", topic: "in", payload: []byte("b")},
+	)
+	sink := NewMemorySink()
+	runner, err := NewRunnerWith(Config{
+		InputMode:  "stdin",
+		OutputMode: "jsonl",
+		Batch:      BatchPolicy{MaxMessages: 2, MaxDelay: time.Minute},
+	}, source, sink)
+	require.NoError(t, err)
+	require.NoError(t, runner.Run(context.Background(), "../scripts/batch.sh"))
+
+	var script sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "script" {
+			script = span
+		}
+	}
+	require.NotNil(t, script)
+	// the batch span links to the receive spans of all the messages
+	assert.False(t, script.Parent().IsValid())
+	assert.Len(t, script.Links(), 2)
+	assert.Equal(t, int64(2), spanAttribute(script, "script.messages").AsInt64())
+}
+
+func TestSetupTracing(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.jsonl")
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	shutdown, err := SetupTracing(TracingConfig{Exporter: "file", File: file})
+	require.NoError(t, err)
+	_, span := tracer().Start(context.Background(), "test-span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	content, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(string(content), `"Name":"test-span"`))
+	assert.True(t, strings.Contains(string(content), `"Value":"bash-runtime"`))
+
+	_, err = SetupTracing(TracingConfig{Exporter: "jaeger"})
+	assert.EqualError(t, err, "unknown trace exporter 'jaeger', should be one of none, otlp, stdout and file")
+	_, err = SetupTracing(TracingConfig{Exporter: "file"})
+	assert.EqualError(t, err, "the file is required by the file exporter")
+}
+
+func TestSetupTracing_OTLP(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer server.Close()
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	shutdown, err := SetupTracing(TracingConfig{Exporter: "otlp", OTLPEndpoint: server.URL})
+	require.NoError(t, err)
+	_, span := tracer().Start(context.Background(), "test-span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	request := <-requests
+	assert.Equal(t, http.MethodPost, request.Method)
+	assert.Equal(t, "/v1/traces", request.URL.Path)
+	assert.Equal(t, "application/x-protobuf", request.Header.Get("Content-Type"))
+}
+
+func TestOTLPOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		endpoint      string
+		expectOptions int
+		expectErr     string
+	}{
+		{
+			name: "it should leave the endpoint to the environments when it's empty",
+		},
+		{
+			name:          "it should send the spans without TLS to an http endpoint",
+			endpoint:      "http://localhost:4318",
+			expectOptions: 2,
+		},
+		{
+			name:          "it should send the spans under the path of the endpoint",
+			endpoint:      "https://collector.example.com/otlp",
+			expectOptions: 2,
+		},
+		{
+			name:      "it should reject an endpoint without scheme",
+			endpoint:  "localhost:4318",
+			expectErr: "invalid otlp endpoint 'localhost:4318', should be like http://localhost:4318",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := otlpOptions(tt.endpoint)
+			if tt.expectErr != "" {
+				assert.EqualError(t, err, tt.expectErr)
+			} else {
+				require.NoError(t, err)
+				assert.Len(t, options, tt.expectOptions)
+			}
+		})
+	}
+}
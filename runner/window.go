@@ -0,0 +1,420 @@
+package runner
+
+import (
+	"bash-runtime/common"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// environment variables describing the window passed to the script
+const (
+	EnvWindowStart = "PULSAR_WINDOW_START"
+	EnvWindowEnd   = "PULSAR_WINDOW_END"
+	EnvWindowSize  = "PULSAR_WINDOW_SIZE"
+)
+
+// WindowPolicy groups the messages into count-based or time-based windows, and runs the script once per window.
+// A window is tumbling when its slide equals its length, or sliding when the slide is shorter.
+type WindowPolicy struct {
+	// LengthCount is the number of messages in a count-based window
+	LengthCount int
+	// SlideCount is the number of messages between two count-based windows, LengthCount is used when it's zero
+	SlideCount int
+	// Length is the duration of a time-based window
+	Length time.Duration
+	// Slide is the duration between two time-based windows, Length is used when it's zero
+	Slide time.Duration
+	// EventTime puts the messages into the time-based windows by their event time, or their publish time when the
+	// event time is not set, instead of the time they're received
+	EventTime bool
+	// AllowedLateness is how long an event-time window waits for the messages out of order, the watermark is the
+	// latest event time received minus it, and a window is processed when the watermark passes its end. When no
+	// message comes, the watermark moves forward by the processing time after waiting the AllowedLateness again, so
+	// that the last windows are processed on a quiet stream.
+	AllowedLateness time.Duration
+}
+
+func (policy WindowPolicy) enabled() bool {
+	return policy.LengthCount > 0 || policy.Length > 0
+}
+
+func (policy WindowPolicy) timeBased() bool {
+	return policy.Length > 0
+}
+
+func (policy WindowPolicy) slideCount() int {
+	if policy.SlideCount <= 0 {
+		return policy.LengthCount
+	}
+	return policy.SlideCount
+}
+
+func (policy WindowPolicy) slide() time.Duration {
+	if policy.Slide <= 0 {
+		return policy.Length
+	}
+	return policy.Slide
+}
+
+// validate checks the policy is either count-based or time-based, and no message is skipped between the windows
+func (policy WindowPolicy) validate() error {
+	switch {
+	case policy.LengthCount > 0 && policy.Length > 0:
+		return errors.New("window can't be both count-based and time-based")
+	case policy.LengthCount > 0 && policy.slideCount() > policy.LengthCount:
+		return errors.New("window slide count can't be larger than its length count")
+	case policy.Length > 0 && policy.slide() > policy.Length:
+		return errors.New("window slide can't be longer than its length")
+	case policy.EventTime && !policy.timeBased():
+		return errors.New("event time is only supported by time-based windows")
+	}
+	return nil
+}
+
+// validateWindow checks the window policy and the modes which can be used with windows
+func validateWindow(policy WindowPolicy, batch BatchPolicy, inputMode InputMode, execMode ExecMode) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	if batch.enabled() {
+		return errors.New("window can't be used in batch mode")
+	}
+	if inputMode != InputModeStdin && inputMode != InputModeFile {
+		return fmt.Errorf("input mode should be stdin or file for windows, got %s", inputMode)
+	}
+	if execMode != ExecModeFork {
+		return fmt.Errorf("exec mode should be fork for windows, got %s", execMode)
+	}
+	return nil
+}
+
+// windowEntry is a message kept in the windows until no more window will contain it
+type windowEntry struct {
+	msg       Message
+	timestamp time.Time
+	// cause is the error of the first failed window containing the message
+	cause  error
+	stderr []byte
+}
+
+// windower collects the messages into windows, a message is released after the last window containing it is processed
+type windower struct {
+	policy  WindowPolicy
+	entries []*windowEntry
+	// received is the number of messages since the last count-based window
+	received int
+	// nextEnd is the end of the next time-based window, it's set by the first message
+	nextEnd   time.Time
+	watermark time.Time
+	// lastArrival is when the last event-time message is received, and idleFrom is the watermark at that time
+	lastArrival time.Time
+	idleFrom    time.Time
+	now         func() time.Time
+	// process runs the script with the window, and returns the stderr and the error if it fails
+	process func(msgs []Message, start, end time.Time) ([]byte, error)
+	// release acks or fails the message depending on its windows, it's called when no more window will contain it
+	release func(entry *windowEntry)
+	// late is called with the event-time message whose windows have all been processed
+	late func(msg Message)
+	// abort is called with the messages which are still in the windows when the channel is closed
+	abort func(msg Message)
+	// hold is called with the number of messages waiting in the windows, the messages of a window being processed
+	// are not waiting. It's optional.
+	hold func(n int)
+}
+
+// run processes the windows until the channel is closed
+func (w *windower) run(ch <-chan Message) {
+	for {
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if w.policy.timeBased() && !w.policy.EventTime && !w.nextEnd.IsZero() {
+			// processing-time windows are triggered by the clock even when no message comes
+			timer = time.NewTimer(w.nextEnd.Sub(w.now()))
+			timeout = timer.C
+		} else if w.policy.EventTime && len(w.entries) > 0 {
+			timer = time.NewTimer(w.idleDelay())
+			timeout = timer.C
+		}
+		select {
+		case msg, ok := <-ch:
+			if timer != nil {
+				timer.Stop()
+			}
+			if !ok {
+				w.close()
+				w.holding()
+				return
+			}
+			w.add(msg)
+		case <-timeout:
+			if w.policy.EventTime {
+				w.advanceIdle()
+			} else {
+				w.advance(w.now())
+			}
+		}
+		w.holding()
+	}
+}
+
+// holding reports the number of messages waiting in the windows
+func (w *windower) holding() {
+	if w.hold != nil {
+		w.hold(len(w.entries))
+	}
+}
+
+// idleWatermark is the watermark of a quiet stream, it moves by the processing time since the last message after the
+// AllowedLateness
+func (w *windower) idleWatermark() time.Time {
+	return w.idleFrom.Add(w.now().Sub(w.lastArrival) - w.policy.AllowedLateness)
+}
+
+// idleDelay is how long the stream can be quiet before the idle watermark passes the end of the next window
+func (w *windower) idleDelay() time.Duration {
+	return w.nextEnd.Sub(w.idleWatermark())
+}
+
+// advanceIdle moves the watermark of a quiet stream, and processes the windows which end before it
+func (w *windower) advanceIdle() {
+	if watermark := w.idleWatermark(); watermark.After(w.watermark) {
+		w.watermark = watermark
+		w.advance(watermark)
+	}
+}
+
+func (w *windower) add(msg Message) {
+	if !w.policy.timeBased() {
+		w.entries = append(w.entries, &windowEntry{msg: msg, timestamp: w.now()})
+		w.received++
+		if w.received < w.policy.slideCount() {
+			return
+		}
+		w.received = 0
+		window := w.entries
+		if len(window) > w.policy.LengthCount {
+			window = window[len(window)-w.policy.LengthCount:]
+		}
+		w.fire(window, window[0].timestamp, window[len(window)-1].timestamp)
+		// the next window contains the last LengthCount-SlideCount messages of this one
+		evicted := len(w.entries) - (w.policy.LengthCount - w.policy.slideCount())
+		w.evict(func(i int, _ *windowEntry) bool {
+			return i < evicted
+		})
+		return
+	}
+
+	timestamp := w.now()
+	if w.policy.EventTime {
+		defer func() {
+			w.lastArrival = w.now()
+			w.idleFrom = w.watermark
+		}()
+		timestamp = msg.EventTime()
+		if timestamp.IsZero() {
+			timestamp = msg.PublishTime()
+		}
+	}
+	if w.nextEnd.IsZero() {
+		// leave room for the earlier messages which are not late yet
+		first := timestamp
+		if w.policy.EventTime {
+			first = timestamp.Add(-w.policy.AllowedLateness)
+		}
+		w.nextEnd = first.Truncate(w.policy.slide()).Add(w.policy.slide())
+	}
+	if timestamp.Before(w.nextEnd.Add(-w.policy.Length)) {
+		// all the windows containing the message have been processed
+		w.late(msg)
+		return
+	}
+	w.entries = append(w.entries, &windowEntry{msg: msg, timestamp: timestamp})
+	if !w.policy.EventTime {
+		w.advance(timestamp)
+		return
+	}
+	if watermark := timestamp.Add(-w.policy.AllowedLateness); watermark.After(w.watermark) {
+		w.watermark = watermark
+		w.advance(watermark)
+	}
+}
+
+// advance processes the time-based windows which end before the clock
+func (w *windower) advance(clock time.Time) {
+	for !clock.Before(w.nextEnd) {
+		// skip the empty windows at once, up to the first one containing the earliest message, so that a message far
+		// ahead of the others doesn't walk through every window in between
+		end := clock.Truncate(w.policy.slide()).Add(w.policy.slide())
+		if earliest, ok := w.earliest(); ok {
+			if first := earliest.Truncate(w.policy.slide()).Add(w.policy.slide()); first.Before(end) {
+				end = first
+			}
+		}
+		if end.After(w.nextEnd) {
+			w.nextEnd = end
+			continue
+		}
+		start := w.nextEnd.Add(-w.policy.Length)
+		var window []*windowEntry
+		for _, entry := range w.entries {
+			if !entry.timestamp.Before(start) && entry.timestamp.Before(w.nextEnd) {
+				window = append(window, entry)
+			}
+		}
+		if len(window) > 0 {
+			sort.SliceStable(window, func(i, j int) bool {
+				return window[i].timestamp.Before(window[j].timestamp)
+			})
+			w.fire(window, start, w.nextEnd)
+		}
+		w.nextEnd = w.nextEnd.Add(w.policy.slide())
+		nextStart := w.nextEnd.Add(-w.policy.Length)
+		w.evict(func(_ int, entry *windowEntry) bool {
+			return entry.timestamp.Before(nextStart)
+		})
+	}
+}
+
+// earliest returns the earliest timestamp of the messages in the windows, it's false when there is no message
+func (w *windower) earliest() (time.Time, bool) {
+	if len(w.entries) == 0 {
+		return time.Time{}, false
+	}
+	earliest := w.entries[0].timestamp
+	for _, entry := range w.entries[1:] {
+		if entry.timestamp.Before(earliest) {
+			earliest = entry.timestamp
+		}
+	}
+	return earliest, true
+}
+
+// fire processes the window, and marks its messages failed when it fails
+func (w *windower) fire(window []*windowEntry, start, end time.Time) {
+	msgs := make([]Message, 0, len(window))
+	for _, entry := range window {
+		msgs = append(msgs, entry.msg)
+	}
+	// the messages are not waiting while the script runs, so that a hanging script is not live
+	if w.hold != nil {
+		w.hold(0)
+	}
+	stderr, err := w.process(msgs, start, end)
+	if err == nil {
+		return
+	}
+	for _, entry := range window {
+		if entry.cause == nil {
+			entry.cause = err
+			entry.stderr = stderr
+		}
+	}
+}
+
+// evict releases the entries which are not needed by the next windows
+func (w *windower) evict(evicted func(i int, entry *windowEntry) bool) {
+	kept := w.entries[:0]
+	for i, entry := range w.entries {
+		if evicted(i, entry) {
+			w.release(entry)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	w.entries = kept
+}
+
+// close aborts the messages whose windows are not all processed, so that they're redelivered
+func (w *windower) close() {
+	for _, entry := range w.entries {
+		w.abort(entry.msg)
+	}
+	w.entries = nil
+}
+
+// newWindowWorkerPool starts a single worker which processes the windows in order
+func (runner *Runner) newWindowWorkerPool(ctx context.Context, scriptFile string) *workerPool {
+	w := &windower{
+		policy: runner.window,
+		now:    time.Now,
+		process: func(msgs []Message, start, end time.Time) ([]byte, error) {
+			return runner.processWindow(ctx, scriptFile, msgs, start, end)
+		},
+		release: func(entry *windowEntry) {
+			switch {
+			case entry.cause == nil:
+				runner.observeSucceeded(entry.msg)
+				runner.source.Ack(entry.msg)
+			case ctx.Err() != nil:
+				// the script is killed because of shutdown, it's not the message's fault
+				runner.source.Nack(entry.msg)
+			default:
+				runner.fail(entry.msg, entry.cause, entry.stderr)
+			}
+		},
+		late: func(msg Message) {
+			runner.filter(msg, "lateness")
+		},
+		abort: func(msg Message) {
+			runner.source.Nack(msg)
+		},
+	}
+	if runner.progress != nil {
+		w.hold = runner.progress.hold
+	}
+	return startWorkerPool(1, false, 0, w.run)
+}
+
+// processWindow runs the script once with the messages of the window, and sends its outputs
+func (runner *Runner) processWindow(ctx context.Context, scriptFile string, msgs []Message, start, end time.Time) ([]byte, error) {
+	input, err := batchInput(msgs, runner.execOptions.inputMode)
+	if err != nil {
+		runner.logFailure(msgs, nil, "failed to encode window: %s", err)
+		return nil, fmt.Errorf("%w: %s", common.ErrScriptExecError, err)
+	}
+	env := []string{
+		EnvWindowStart + "=" + formatTime(start),
+		EnvWindowEnd + "=" + formatTime(end),
+		EnvWindowSize + "=" + strconv.Itoa(len(msgs)),
+	}
+	began := time.Now()
+	stdout, stderr, err := runner.runScript(msgs, func(traceEnv []string) ([]byte, []byte, error) {
+		return execScript(ctx, scriptFile, input, append(env, traceEnv...), runner.execOptions)
+	})
+	duration := time.Since(began)
+	if runner.filterPolicy.filtersExit(err) {
+		runner.logger.Infof("window [%s, %s) of %d messages is filtered by exit code", formatTime(start), formatTime(end), len(msgs))
+		return nil, nil
+	}
+	if err != nil {
+		runner.logFailure(msgs, scriptFields(err, duration), "failed to process window of %d messages: %s", len(msgs), err)
+		if len(stderr) > 0 {
+			runner.logger.Errorf("error: %s", stderr)
+		}
+		return stderr, err
+	}
+
+	if len(stderr) > 0 {
+		runner.logger.Errorf("error: %s", stderr)
+	}
+	outputs, err := parseOutput(stdout, runner.execOptions.outputMode)
+	if err != nil {
+		runner.logFailure(msgs, scriptFields(nil, duration), "failed to process window of %d messages: %s", len(msgs), err)
+		return stderr, err
+	}
+	if runner.filterPolicy.filtersOutput(stdout, outputs) {
+		runner.logger.Infof("window [%s, %s) of %d messages has no output", formatTime(start), formatTime(end), len(msgs))
+		return nil, nil
+	}
+	if err = runner.send(msgs, outputs); err != nil {
+		runner.logFailure(msgs, scriptFields(nil, duration), "failed to send window output to topic: %s", err)
+		return stderr, err
+	}
+	runner.logger.Infof("process window [%s, %s) of %d messages successfully", formatTime(start), formatTime(end), len(msgs))
+	return nil, nil
+}
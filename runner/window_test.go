@@ -0,0 +1,266 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingWindower records the processed windows and the released messages by their payloads
+type recordingWindower struct {
+	*windower
+	clock    time.Time
+	windows  []string
+	bounds   [][2]time.Time
+	acked    []string
+	failed   []string
+	late     []string
+	aborted  []string
+	failWith string
+}
+
+func newRecordingWindower(policy WindowPolicy) *recordingWindower {
+	r := &recordingWindower{clock: time.Unix(1000, 0)}
+	r.windower = &windower{
+		policy: policy,
+		now: func() time.Time {
+			return r.clock
+		},
+		process: func(msgs []Message, start, end time.Time) ([]byte, error) {
+			var payloads []string
+			for _, msg := range msgs {
+				payloads = append(payloads, string(msg.Payload()))
+			}
+			window := strings.Join(payloads, ",")
+			r.windows = append(r.windows, window)
+			r.bounds = append(r.bounds, [2]time.Time{start, end})
+			if window == r.failWith {
+				return []byte("failed"), errors.New("failed")
+			}
+			return nil, nil
+		},
+		release: func(entry *windowEntry) {
+			if entry.cause != nil {
+				r.failed = append(r.failed, string(entry.msg.Payload()))
+			} else {
+				r.acked = append(r.acked, string(entry.msg.Payload()))
+			}
+		},
+		late: func(msg Message) {
+			r.late = append(r.late, string(msg.Payload()))
+		},
+		abort: func(msg Message) {
+			r.aborted = append(r.aborted, string(msg.Payload()))
+		},
+	}
+	return r
+}
+
+func TestWindower_Count(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        WindowPolicy
+		payloads      []string
+		failWith      string
+		expectWindows []string
+		expectAcked   []string
+		expectFailed  []string
+		expectAborted []string
+	}{
+		{
+			name:          "it should process tumbling count windows",
+			policy:        WindowPolicy{LengthCount: 2},
+			payloads:      []string{"a", "b", "c", "d", "e"},
+			expectWindows: []string{"a,b", "c,d"},
+			expectAcked:   []string{"a", "b", "c", "d"},
+			expectAborted: []string{"e"},
+		},
+		{
+			name:          "it should process sliding count windows",
+			policy:        WindowPolicy{LengthCount: 3, SlideCount: 1},
+			payloads:      []string{"a", "b", "c", "d"},
+			expectWindows: []string{"a", "a,b", "a,b,c", "b,c,d"},
+			expectAcked:   []string{"a", "b"},
+			expectAborted: []string{"c", "d"},
+		},
+		{
+			name:          "it should fail the messages of the failed window after their last window",
+			policy:        WindowPolicy{LengthCount: 2, SlideCount: 1},
+			payloads:      []string{"a", "b", "c"},
+			failWith:      "a,b",
+			expectWindows: []string{"a", "a,b", "b,c"},
+			expectFailed:  []string{"a", "b"},
+			expectAborted: []string{"c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRecordingWindower(tt.policy)
+			w.failWith = tt.failWith
+			for _, payload := range tt.payloads {
+				w.add(&mockMessage{payload: []byte(payload)})
+			}
+			w.close()
+			assert.Equal(t, tt.expectWindows, w.windows)
+			assert.Equal(t, tt.expectAcked, w.acked)
+			assert.Equal(t, tt.expectFailed, w.failed)
+			assert.Equal(t, tt.expectAborted, w.aborted)
+		})
+	}
+}
+
+func TestWindower_ProcessingTime(t *testing.T) {
+	w := newRecordingWindower(WindowPolicy{Length: 10 * time.Second, Slide: 5 * time.Second})
+	start := w.clock
+
+	w.add(&mockMessage{payload: []byte("a")})
+	w.clock = start.Add(3 * time.Second)
+	w.add(&mockMessage{payload: []byte("b")})
+	w.clock = start.Add(7 * time.Second)
+	w.add(&mockMessage{payload: []byte("c")})
+	assert.Equal(t, []string{"a,b"}, w.windows)
+
+	// the clock triggers the windows without new messages
+	w.advance(start.Add(10 * time.Second))
+	assert.Equal(t, []string{"a,b", "a,b,c"}, w.windows)
+	assert.Equal(t, [2]time.Time{start.Add(-5 * time.Second), start.Add(5 * time.Second)}, w.bounds[0])
+	assert.Equal(t, []string{"a", "b"}, w.acked)
+	w.advance(start.Add(15 * time.Second))
+	assert.Equal(t, []string{"a,b", "a,b,c", "c"}, w.windows)
+	assert.Equal(t, []string{"a", "b", "c"}, w.acked)
+}
+
+func TestWindower_EventTime(t *testing.T) {
+	w := newRecordingWindower(WindowPolicy{Length: 10 * time.Second, EventTime: true, AllowedLateness: 5 * time.Second})
+	base := time.Unix(1000, 0)
+	add := func(payload string, offset time.Duration) {
+		w.add(&mockMessage{payload: []byte(payload), eventTime: base.Add(offset * time.Second)})
+	}
+
+	add("a", 1)
+	add("b", 12)
+	// the watermark is 7s, the window [0s, 10s) is still open for the messages out of order
+	add("c", 3)
+	assert.Nil(t, w.windows)
+	add("d", 16)
+	assert.Equal(t, []string{"a,c"}, w.windows)
+	assert.Equal(t, []string{"a", "c"}, w.acked)
+	// the window [0s, 10s) has been processed
+	add("e", 5)
+	assert.Equal(t, []string{"e"}, w.late)
+	add("f", 26)
+	assert.Equal(t, []string{"a,c", "b,d"}, w.windows)
+	w.close()
+	assert.Equal(t, []string{"f"}, w.aborted)
+}
+
+func TestWindower_EventTimeIdle(t *testing.T) {
+	w := newRecordingWindower(WindowPolicy{Length: 10 * time.Second, EventTime: true, AllowedLateness: 5 * time.Second})
+	var held []int
+	w.hold = func(n int) {
+		held = append(held, n)
+	}
+	base, arrival := time.Unix(1000, 0), w.clock
+	w.add(&mockMessage{payload: []byte("a"), eventTime: base.Add(1 * time.Second)})
+	w.add(&mockMessage{payload: []byte("b"), eventTime: base.Add(12 * time.Second)})
+	w.holding()
+	assert.Nil(t, w.windows)
+
+	// the watermark is 7s, it reaches the end of [0s, 10s) 3s later plus the allowed lateness
+	assert.Equal(t, 8*time.Second, w.idleDelay())
+	w.clock = arrival.Add(7 * time.Second)
+	w.advanceIdle()
+	assert.Nil(t, w.windows)
+	w.clock = arrival.Add(8 * time.Second)
+	w.advanceIdle()
+	assert.Equal(t, []string{"a"}, w.windows)
+	assert.Equal(t, 10*time.Second, w.idleDelay())
+	w.clock = arrival.Add(18 * time.Second)
+	w.advanceIdle()
+	w.holding()
+	assert.Equal(t, []string{"a", "b"}, w.windows)
+	assert.Equal(t, []string{"a", "b"}, w.acked)
+	// the messages are not held while their windows are processed
+	assert.Equal(t, []int{2, 0, 0, 0}, held)
+}
+
+func TestWindower_EventTimeFarAhead(t *testing.T) {
+	w := newRecordingWindower(WindowPolicy{Length: 2 * time.Second, Slide: time.Second, EventTime: true})
+	base := time.Unix(1000, 0)
+	w.add(&mockMessage{payload: []byte("a"), eventTime: base})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// the empty windows between the two messages are skipped at once
+		w.add(&mockMessage{payload: []byte("b"), eventTime: base.AddDate(50, 0, 0)})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the message far ahead of the watermark is not added")
+	}
+	assert.Equal(t, []string{"a", "a"}, w.windows)
+	assert.Equal(t, []string{"a"}, w.acked)
+	w.close()
+	assert.Equal(t, []string{"b"}, w.aborted)
+}
+
+func TestWindowPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      WindowPolicy
+		expectError bool
+	}{
+		{
+			name:   "it should accept sliding time windows",
+			policy: WindowPolicy{Length: time.Minute, Slide: 10 * time.Second, EventTime: true},
+		},
+		{
+			name:        "it should reject the window with both count and time",
+			policy:      WindowPolicy{LengthCount: 10, Length: time.Minute},
+			expectError: true,
+		},
+		{
+			name:        "it should reject the slide longer than the length",
+			policy:      WindowPolicy{LengthCount: 10, SlideCount: 20},
+			expectError: true,
+		},
+		{
+			name:        "it should reject event time for count windows",
+			policy:      WindowPolicy{LengthCount: 10, EventTime: true},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectError, tt.policy.validate() != nil)
+		})
+	}
+}
+
+func TestRunner_RunWindow(t *testing.T) {
+	requireJQ(t)
+	source := newMemorySource(&mockMessage{payload: []byte("a")}, &mockMessage{payload: []byte("b")},
+		&mockMessage{payload: []byte("c")})
+	sink := NewMemorySink()
+	runner := &Runner{
+		source:      source,
+		sink:        sink,
+		logger:      logrus.New(),
+		execOptions: execOptions{inputMode: InputModeStdin, outputMode: OutputModeRaw},
+		window:      WindowPolicy{LengthCount: 2},
+	}
+
+	err := runner.Run(context.Background(), "../scripts/window.sh")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(source.Acked()))
+	assert.Equal(t, 1, len(source.Nacked()))
+	assert.Equal(t, 1, len(sink.Sent("")))
+	assert.Equal(t, "2: a,b", string(sink.Sent("")[0].Payload))
+}
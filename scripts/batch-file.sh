@@ -0,0 +1,4 @@
+#!/usr/bin/env bash
+
+# read the batch from the JSON array in the file, and write the output records to stdout
+jq -c '.[] | {index, key, payload: (.payload + "!")}' "$1"
@@ -0,0 +1,8 @@
+#!/usr/bin/env bash
+
+# read the batch from stdin one record per line, and write an output record for every input record
+jq --unbuffered -c '
+  if .payload == "fail" then {index, error: "bad input"}
+  elif .payload == "skip" then empty
+  else {index, payload: (.payload + "!")}
+  end'
@@ -0,0 +1,3 @@
+#!/usr/bin/env bash
+
+echo -n $PULSAR_MSG_TOPIC $PULSAR_MSG_KEY $PULSAR_PROP_trace_id
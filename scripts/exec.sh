@@ -1,3 +1,7 @@
 #!/usr/bin/env bash
 
+# read the payload from stdin when it's not passed as the argument
+if [ $# -eq 0 ]; then
+  set -- "$(cat)"
+fi
 echo -n $@!
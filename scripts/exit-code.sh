@@ -0,0 +1,4 @@
+#!/usr/bin/env bash
+
+echo -n exit with $1 >&2
+exit $1
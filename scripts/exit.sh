@@ -0,0 +1,4 @@
+#!/usr/bin/env bash
+
+echo -n $@ >&2
+exit 1
@@ -0,0 +1,9 @@
+#!/usr/bin/env bash
+
+# drop the message by exit code 3, or by printing nothing
+if [ "$1" == "drop" ]; then
+  exit 3
+fi
+if [ "$1" != "empty" ]; then
+  echo -n $@!
+fi
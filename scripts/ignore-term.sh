@@ -0,0 +1,6 @@
+#!/usr/bin/env bash
+
+trap "" TERM
+sleep $1 &
+wait
+echo -n $@!
@@ -0,0 +1,4 @@
+#!/usr/bin/env bash
+
+echo "this line is logged"
+echo "{\"payload\": \"$@!\"}" >&$PULSAR_OUTPUT_FD
@@ -0,0 +1,5 @@
+#!/usr/bin/env bash
+
+# write one output record per line, the first one to the OUT_TOPIC, and the second one to another topic
+echo "{\"key\": \"$PULSAR_MSG_KEY\", \"properties\": {\"source\": \"bash\"}, \"payload\": \"$@!\"}"
+echo "{\"topic\": \"audit\", \"payload_b64\": \"$(echo -n $@ | base64)\"}"
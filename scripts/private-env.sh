@@ -0,0 +1,3 @@
+#!/usr/bin/env bash
+
+echo -n "$PULSAR_MSG_TOPIC $AUTH_TOKEN $TLS_KEY_FILE"
@@ -0,0 +1,5 @@
+#!/usr/bin/env bash
+
+# join the payloads of the window read from stdin
+echo -n "$PULSAR_WINDOW_SIZE: "
+jq -rs 'map(.payload) | join(",")'
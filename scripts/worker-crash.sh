@@ -0,0 +1,5 @@
+#!/usr/bin/env bash
+
+read -r line
+echo "crash" >&2
+exit 1
@@ -0,0 +1,4 @@
+#!/usr/bin/env bash
+
+# respond with the environments which should not be passed to the worker
+exec jq --unbuffered -c '{id, exit_code: 0, output: ("\(env.AUTH_TOKEN // "") \(env.TLS_KEY_FILE // "")")}'
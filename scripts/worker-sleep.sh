@@ -0,0 +1,5 @@
+#!/usr/bin/env bash
+
+while read -r line; do
+  sleep 10
+done
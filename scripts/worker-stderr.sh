@@ -0,0 +1,8 @@
+#!/usr/bin/env bash
+
+# write a stderr line of 2MB before every response, which is longer than the pipe buffer and the logged lines
+while IFS= read -r request; do
+  head -c 2097152 /dev/zero | tr '\0' 'x' >&2
+  printf "\ndone\n" >&2
+  jq -c '{id, exit_code: 0, output: (.payload + "!")}' <<< "$request"
+done
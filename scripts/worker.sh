@@ -0,0 +1,7 @@
+#!/usr/bin/env bash
+
+# handle the requests one per line from stdin, and write the responses to stdout in the same order
+exec jq --unbuffered -c '
+  if .payload == "fail" then {id, exit_code: 2, stderr: "failed"}
+  else {id, exit_code: 0, output: (.payload + "!")}
+  end'
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bash-runtime/runner"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+)
+
+var errRunnerNotCreated = errors.New("runner is not created yet")
+
+// statusServer serves the metrics and the probes of the runner in the background, the runtime keeps running when it
+// fails to listen
+type statusServer struct {
+	server *http.Server
+	mu     sync.RWMutex
+	// runner is nil until it's created
+	runner *runner.Runner
+}
+
+func startStatusServer(address string) *statusServer {
+	status := &statusServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", status.metrics)
+	mux.HandleFunc("/healthz", status.healthz)
+	mux.HandleFunc("/readyz", status.readyz)
+	status.server = &http.Server{Addr: address, Handler: mux}
+	go func() {
+		if err := status.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Failed to serve http on %s: %s", address, err)
+		}
+	}()
+	return status
+}
+
+// setRunner makes the probes check the runner, it does nothing when the server is disabled
+func (status *statusServer) setRunner(scriptRunner *runner.Runner) {
+	if status == nil {
+		return
+	}
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.runner = scriptRunner
+}
+
+func (status *statusServer) current() *runner.Runner {
+	status.mu.RLock()
+	defer status.mu.RUnlock()
+	return status.runner
+}
+
+func (status *statusServer) metrics(w http.ResponseWriter, r *http.Request) {
+	scriptRunner := status.current()
+	if scriptRunner == nil {
+		http.Error(w, errRunnerNotCreated.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	scriptRunner.MetricsHandler().ServeHTTP(w, r)
+}
+
+// healthz fails when the runner is stuck, it passes while the runner is being created so that a slow broker doesn't
+// restart the runtime
+func (status *statusServer) healthz(w http.ResponseWriter, _ *http.Request) {
+	var err error
+	if scriptRunner := status.current(); scriptRunner != nil {
+		err = scriptRunner.Live()
+	}
+	writeProbe(w, err)
+}
+
+// readyz fails until the runner is created, and while the connection to pulsar is down
+func (status *statusServer) readyz(w http.ResponseWriter, _ *http.Request) {
+	err := errRunnerNotCreated
+	if scriptRunner := status.current(); scriptRunner != nil {
+		err = scriptRunner.Ready()
+	}
+	writeProbe(w, err)
+}
+
+func writeProbe(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+func (status *statusServer) close() {
+	_ = status.server.Close()
+}